@@ -6,9 +6,11 @@ package goph
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -27,12 +29,91 @@ type Client struct {
 	Port     uint
 	ProxyURL string
 	Jump     *Client
+
+	// JumpHosts is a chain of jump hosts Dial connects through in order,
+	// the jump clients are created by Dial and closed with the client.
+	JumpHosts []JumpHost
+
+	// ProxyTLSConfig is the TLS config used for https proxies, nil means defaults.
+	ProxyTLSConfig *tls.Config
+
+	// ProxyLocalDNS resolves the target host locally with socks5:// proxies,
+	// by default the proxy resolves it.
+	ProxyLocalDNS bool
+
+	// ProxyCommand is a local command whose stdin and stdout are used as the
+	// connection to the server, like the OpenSSH ProxyCommand.
+	ProxyCommand string
+
+	// KeepAliveInterval is the interval between keepalive probes, zero disables them.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveMaxMissed is the number of unanswered probes after which the
+	// client is closed, zero means DefaultKeepAliveMaxMissed.
+	KeepAliveMaxMissed int
+
+	// Reconnect, if non-nil, redials the connection when it dies, see WithReconnect.
+	Reconnect *ReconnectPolicy
+
+	// OutputLimit is the default OutputLimit of the client commands.
+	OutputLimit OutputLimit
+
+	// config is the client config of the last Dial, reused to reconnect.
+	config *ssh.ClientConfig
+
+	// sshConfig is the ssh_config path applied by WithSSHConfig, reused for jump hosts.
+	sshConfig string
+
+	// proxyJump is the ProxyJump value resolved from ssh_config.
+	proxyJump string
+
+	// sudoPassword is the cached password of Sudo.
+	sudoPassword string
+
+	// authIDs identify the auth methods added by options, used as Pool key.
+	authIDs []string
+
+	// hostKeyID identifies the host key policy set by options, used as Pool key.
+	hostKeyID string
+
+	// unkeyed are the options a Pool cannot identify, see WithPoolKey.
+	unkeyed []string
+
+	// poolKey is the WithPoolKey key.
+	poolKey string
+
+	// jumps are the jump clients created by Dial, closed with the client.
+	jumps []*Client
+
+	mu        sync.Mutex
+	done      chan struct{}
+	ready     chan struct{}
+	closing   chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// JumpHost is a hop of a jump chain.
+type JumpHost struct {
+
+	// Spec is the hop address in the ProxyJump [user@]host[:port] form.
+	Spec string
+
+	// Options are applied to the hop after the auth and host key settings
+	// inherited from the target connection.
+	Options []Option
 }
 
 // New starts a new SSH connection.
 // By default it uses the default known_hosts file for host key verification,
 // port 22, and a 20 second timeout. Override with With* options.
 func New(user, addr string, opts ...Option) (*Client, error) {
+	return NewContext(context.Background(), user, addr, opts...)
+}
+
+// NewContext is like New but uses ctx to cancel the connection establishment,
+// including the SSH handshake. See DialContext.
+func NewContext(ctx context.Context, user, addr string, opts ...Option) (*Client, error) {
 
 	c := &Client{
 		User: user,
@@ -52,7 +133,7 @@ func New(user, addr string, opts ...Option) (*Client, error) {
 		}
 	}
 
-	if err := Dial(c, config); err != nil {
+	if err := DialContext(ctx, c, config); err != nil {
 		return nil, err
 	}
 
@@ -60,19 +141,9 @@ func New(user, addr string, opts ...Option) (*Client, error) {
 }
 
 // Run starts a new SSH session and runs the cmd, it returns CombinedOutput and err if any.
+// A non zero exit status is returned as *ExitError.
 func (c *Client) Run(cmd string) ([]byte, error) {
-
-	var (
-		err  error
-		sess *ssh.Session
-	)
-
-	if sess, err = c.NewSession(); err != nil {
-		return nil, err
-	}
-	defer sess.Close()
-
-	return sess.CombinedOutput(cmd)
+	return c.RunContext(context.Background(), cmd)
 }
 
 // RunContext starts a new SSH session with context and runs the cmd.
@@ -81,6 +152,7 @@ func (c *Client) RunContext(ctx context.Context, name string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer cmd.Close()
 
 	return cmd.CombinedOutput()
 }
@@ -93,62 +165,102 @@ func (c *Client) Command(name string, args ...string) (*Cmd, error) {
 // CommandContext returns new Cmd with context and error, if any.
 func (c *Client) CommandContext(ctx context.Context, name string, args ...string) (*Cmd, error) {
 
-	sess, err := c.NewSession()
+	sess, exit, err := c.newSession()
 	if err != nil {
 		return nil, err
 	}
 
 	return &Cmd{
-		ctx:     ctx,
-		Path:    name,
-		Args:    args,
-		Session: sess,
+		ctx:         ctx,
+		client:      c,
+		Path:        name,
+		Args:        args,
+		Session:     sess,
+		OutputLimit: c.OutputLimit,
+		exit:        exit,
 	}, nil
 }
 
-// NewSftp returns a new SFTP client.
-func (c *Client) NewSftp(opts ...sftp.ClientOption) (*sftp.Client, error) {
-	return sftp.NewClient(c.Client, opts...)
-}
+// NewSession opens a new session, if the client is reconnecting it waits
+// for the connection to be established again.
+func (c *Client) NewSession() (*ssh.Session, error) {
 
-// Close closes the SSH connection.
-func (c *Client) Close() error {
-	return c.Client.Close()
+	sess, _, err := c.newSession()
+	return sess, err
 }
 
-// Upload a local file to the remote server.
-func (c *Client) Upload(localPath string, remotePath string) (err error) {
+// newSession opens a new session and returns its channel when the
+// connection was dialed by goph, nil otherwise.
+func (c *Client) newSession() (*ssh.Session, *exitChannel, error) {
 
-	local, err := os.Open(localPath)
+	client, err := c.connected()
 	if err != nil {
-		return
+		return nil, nil, err
 	}
-	defer local.Close()
 
-	ftp, err := c.NewSftp()
-	if err != nil {
-		return
+	conn, ok := client.Conn.(*exitConn)
+	if !ok {
+		sess, err := client.NewSession()
+		return sess, nil, err
 	}
-	defer ftp.Close()
 
-	remote, err := ftp.Create(remotePath)
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	sess, err := client.NewSession()
 	if err != nil {
-		return
+		return nil, nil, err
 	}
-	defer remote.Close()
 
-	_, err = io.Copy(remote, local)
-	return
+	return sess, conn.opened.Swap(nil), nil
 }
 
-// Download file from remote server.
-func (c *Client) Download(remotePath string, localPath string) (err error) {
+// NewSftp returns a new SFTP client.
+func (c *Client) NewSftp(opts ...sftp.ClientOption) (*sftp.Client, error) {
 
-	local, err := os.Create(localPath)
+	client, err := c.connected()
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	return sftp.NewClient(client, opts...)
+}
+
+// Close closes the SSH connection and the jump clients created for it.
+func (c *Client) Close() error {
+
+	c.setErr(ErrClosed)
+	c.closeOnce.Do(func() {
+		close(c.closingChan())
+	})
+
+	err := c.current().Close()
+	if jerr := c.closeJumps(); err == nil {
+		err = jerr
+	}
+
+	return err
+}
+
+// closeJumps closes the jump clients created by Dial, last hop first.
+func (c *Client) closeJumps() (err error) {
+
+	c.mu.Lock()
+	jumps := c.jumps
+	c.jumps = nil
+	c.mu.Unlock()
+
+	for i := len(jumps) - 1; i >= 0; i-- {
+		if jerr := jumps[i].Close(); err == nil {
+			err = jerr
+		}
 	}
-	defer local.Close()
+
+	return err
+}
+
+// Upload a local file to the remote server.
+func (c *Client) Upload(localPath string, remotePath string, opts ...TransferOption) (err error) {
 
 	ftp, err := c.NewSftp()
 	if err != nil {
@@ -156,30 +268,79 @@ func (c *Client) Download(remotePath string, localPath string) (err error) {
 	}
 	defer ftp.Close()
 
-	remote, err := ftp.Open(remotePath)
+	t := newTransfer(ftp, opts)
+	defer t.finish()
+
+	return t.upload(localPath, remotePath)
+}
+
+// Download file from remote server.
+func (c *Client) Download(remotePath string, localPath string, opts ...TransferOption) (err error) {
+
+	ftp, err := c.NewSftp()
 	if err != nil {
 		return
 	}
-	defer remote.Close()
+	defer ftp.Close()
 
-	if _, err = io.Copy(local, remote); err != nil {
-		return
-	}
+	t := newTransfer(ftp, opts)
+	defer t.finish()
 
-	return local.Sync()
+	return t.download(remotePath, localPath)
 }
 
 // Script runs a script from an io.Reader on the remote host via /bin/sh you can override (with WithPath).
+//
+// The script is piped to the interpreter stdin, WithScriptArgs passes it
+// positional args and WithShebang runs it with its "#!" interpreter.
+// With WithScriptUpload, the script is uploaded to a temp file instead, so
+// cmd.Stdin is free for the script, the file is run with the interpreter and
+// removed by Wait, Close or a failed Start.
 func (c *Client) Script(ctx context.Context, r io.Reader, opts ...CmdOption) (cmd *Cmd, err error) {
 
 	if cmd, err = c.CommandContext(ctx, "/bin/sh"); err != nil {
 		return nil, err
 	}
 
+	cmd.script = &scriptOptions{}
 	for _, opt := range opts {
 		opt(cmd)
 	}
-	cmd.Stdin = r
+
+	script := cmd.script
+
+	if script.shebang {
+
+		var interpreter string
+		if interpreter, r, err = shebang(r); err != nil {
+			cmd.Close()
+			return nil, fmt.Errorf("goph: read script: %w", err)
+		}
+
+		if interpreter != "" {
+			cmd.Path = interpreter
+		}
+	}
+
+	if !script.upload {
+		cmd.Stdin = r
+		if len(script.args) > 0 {
+			cmd.Args = stdinArgs(cmd.Path, script.args)
+		}
+		return cmd, nil
+	}
+
+	if script.file, err = c.uploadScript(r, script.dir); err != nil {
+		cmd.Close()
+		return nil, err
+	}
+
+	cmd.closeAfterWait = append(cmd.closeAfterWait, closerFunc(cmd.removeScript))
+
+	// The file is passed to the interpreter instead of being executed, which
+	// fails on a noexec mount.
+	cmd.Path += " " + Quote(script.file)
+	cmd.Args = script.args
 
 	return cmd, nil
 }
@@ -5,12 +5,24 @@ package goph
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// DefaultWaitDelay is the default grace period between the cancellation steps of a Cmd.
+const DefaultWaitDelay = 2 * time.Second
+
+// DefaultCancelSignals are the signals sent in order to a Cmd when its context is done.
+var DefaultCancelSignals = []ssh.Signal{ssh.SIGINT, ssh.SIGTERM, ssh.SIGKILL}
+
 // Cmd it's like os/exec.Cmd but for ssh session.
 type Cmd struct {
 
@@ -20,65 +32,378 @@ type Cmd struct {
 	// Path to command executable filename
 	Path string
 
-	// Command args.
+	// Command args, each one is shell quoted unless Raw is set.
 	Args []string
 
-	// Session env vars.
+	// Raw disables the quoting of Args, they are joined as is and the remote
+	// shell interprets them. Only use it for trusted shell syntax.
+	Raw bool
+
+	// Dir is the working directory of the command, the user home if empty.
+	Dir string
+
+	// Session env vars, as "NAME=value".
 	Env []string
 
+	// EnvMode is how Env is passed to the command (default EnvSetenv).
+	EnvMode EnvMode
+
+	// envExports are the "NAME=value" pairs exported in the command line, see EnvMode.
+	envExports []string
+
 	// Cancel is called when Context is done.
-	// If non-nil, it replaces the default ssh.SIGINT signal.
-	// If nil, ssh.SIGINT is sent on cancellation.
+	// If non-nil, it replaces the CancelSignals, the session is still
+	// closed if the command has not exited after WaitDelay.
 	Cancel func() error
 
+	// CancelSignals are sent in order when Context is done, waiting WaitDelay
+	// after each one for the command to exit, then the session is closed.
+	// If nil, DefaultCancelSignals are used.
+	CancelSignals []ssh.Signal
+
+	// WaitDelay is the grace period after each cancellation step.
+	// If zero, DefaultWaitDelay is used.
+	WaitDelay time.Duration
+
+	// KillProcessGroup records the remote process group of the command and
+	// sends each of CancelSignals to the whole group from a second session,
+	// for servers that ignore signal requests. The command line is prefixed
+	// with a POSIX shell prelude, so the login shell must be sh compatible.
+	KillProcessGroup bool
+
+	// pidFile is the remote file holding the process group id, see KillProcessGroup.
+	pidFile string
+
+	// exited is closed by Wait when the command exits, watched when the
+	// context watcher returns.
+	exited, watched chan struct{}
+
+	// exit is the session channel keeping the core dumped flag, nil if the
+	// client connection was not dialed by goph.
+	exit *exitChannel
+
+	// waited reports whether Wait was called.
+	waited bool
+
+	// interruptErr is the context error or ErrOutputLimit when the command
+	// was interrupted.
+	interruptErr error
+
+	// abort is closed to interrupt the command, see OutputLimit.Abort.
+	abort     chan struct{}
+	abortOnce sync.Once
+
+	// OutputLimit bounds the output captured by Output and CombinedOutput,
+	// it defaults to the client OutputLimit.
+	OutputLimit OutputLimit
+
+	// output is the captured output of Output and CombinedOutput.
+	output *limitBuffer
+
+	// closeAfterWait are the pipes and line writers closed by Wait.
+	closeAfterWait []io.Closer
+
+	// stdoutLines and stderrLines are the line callbacks, see WithLines.
+	stdoutLines, stderrLines []func(Line)
+
+	// linesMu serializes the line callbacks of both streams.
+	linesMu sync.Mutex
+
+	// umask is the octal file mode creation mask, see WithUmask.
+	umask string
+
+	// sudo runs the command as another user, see WithSudo.
+	sudo *sudoState
+
+	// script holds the Script settings, nil for other commands.
+	script *scriptOptions
+
+	// stdinPipe, stdoutPipe and stderrPipe report whether the pipes were requested.
+	stdinPipe, stdoutPipe, stderrPipe bool
+
 	initialized atomic.Bool
 
 	// ctx for cancellation
 	ctx context.Context
+
+	// client that created the command.
+	client *Client
+
+	// started is the time the command was started.
+	started time.Time
+
+	// stderrTail captures the stderr tail for ExitError when Stderr is not set.
+	stderrTail *tailBuffer
+
+	// pty is the pseudo terminal requested before the command starts, see WithPty.
+	pty *ptyRequest
+}
+
+// ptyRequest holds the pseudo terminal settings of a Cmd.
+type ptyRequest struct {
+	term       string
+	cols, rows int
+	modes      ssh.TerminalModes
+}
+
+// Apply applies the given options to the command, it must be called before the command starts.
+func (c *Cmd) Apply(opts ...CmdOption) {
+	for _, opt := range opts {
+		opt(c)
+	}
+}
+
+// Resize sends a window-change request with the new terminal size,
+// the command must have a pseudo terminal, see WithPty.
+func (c *Cmd) Resize(cols, rows int) error {
+
+	if c.pty == nil {
+		return fmt.Errorf("goph: resize: command has no pty")
+	}
+
+	c.pty.cols, c.pty.rows = cols, rows
+	return c.WindowChange(rows, cols)
 }
 
 // CombinedOutput runs cmd on the remote host and returns its combined stdout and stderr.
+// If OutputLimit dropped bytes, the error is a *TruncatedError.
 func (c *Cmd) CombinedOutput() ([]byte, error) {
 
-	return c.runInContext(func() ([]byte, error) {
-		return c.Session.CombinedOutput(c.String())
-	})
+	if c.Stdout != nil {
+		return nil, errors.New("goph: Stdout already set")
+	}
+
+	if c.Stderr != nil {
+		return nil, errors.New("goph: Stderr already set")
+	}
+
+	c.output = c.newOutput()
+	c.Stdout, c.Stderr = c.output, c.output
+
+	return c.output.result(c.Run())
 }
 
 // Output runs cmd on the remote host and returns its stdout.
+// If Stderr is nil, the stderr tail is captured in the returned *ExitError.
+// If OutputLimit dropped bytes, the error is a *TruncatedError.
 func (c *Cmd) Output() ([]byte, error) {
 
-	return c.runInContext(func() ([]byte, error) {
-		return c.Session.Output(c.String())
-	})
+	if c.Stdout != nil {
+		return nil, errors.New("goph: Stdout already set")
+	}
+
+	c.output = c.newOutput()
+	c.Stdout = c.output
+
+	if c.Stderr == nil {
+		c.stderrTail = &tailBuffer{max: stderrTailSize}
+		c.Stderr = c.stderrTail
+	}
+
+	return c.output.result(c.Run())
+}
+
+// Truncated reports whether the output returned by Output or CombinedOutput
+// was truncated by OutputLimit.
+func (c *Cmd) Truncated() bool {
+	return c.output != nil && c.output.Truncated()
 }
 
-// Run runs cmd on the remote host.
-func (c *Cmd) Run() (err error) {
+// Run starts cmd on the remote host and waits for it to complete.
+func (c *Cmd) Run() error {
 
-	_, err = c.runInContext(func() ([]byte, error) {
-		return nil, c.Session.Run(c.String())
-	})
-	return
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	return c.Wait()
 }
 
-// Start runs the command on the remote host.
-func (c *Cmd) Start() (err error) {
+// Start starts cmd on the remote host but does not wait for it to complete.
+// If the context is done before the command exits, it is interrupted, see
+// CancelSignals. Wait must be called to release the command resources.
+func (c *Cmd) Start() error {
 
-	_, err = c.runInContext(func() ([]byte, error) {
-		return nil, c.Session.Start(c.String())
+	err := c.start(func() error {
+		return c.Session.Start(c.command())
 	})
-	return
+
+	if err != nil {
+		c.removeScript()
+	}
+
+	return err
+}
+
+// Close closes the command session, and removes the uploaded script of
+// Client.Script if Wait was not called.
+func (c *Cmd) Close() error {
+
+	err := c.Session.Close()
+	c.removeScript()
+
+	return err
+}
+
+// Wait waits for the command to exit and for the copies of its stdin, stdout
+// and stderr to complete, then closes the pipes, like os/exec.Cmd.Wait.
+// All reads from the pipes must be done before calling Wait.
+//
+// If the command was interrupted because the context is done, the context
+// error is returned once the command has exited or its session is closed,
+// ErrOutputLimit if it was aborted by OutputLimit.
+func (c *Cmd) Wait() error {
+
+	if c.exited == nil {
+		return errors.New("goph: not started")
+	}
+
+	if c.waited {
+		return errors.New("goph: Wait was already called")
+	}
+	c.waited = true
+
+	err := c.Session.Wait()
+	close(c.exited)
+	<-c.watched
+
+	for _, closer := range c.closeAfterWait {
+		closer.Close()
+	}
+
+	if c.sudo != nil {
+		err = c.sudo.wrapError(c.wrapError(err))
+	} else {
+		err = c.wrapError(err)
+	}
+
+	if c.interruptErr != nil {
+
+		if c.pidFile != "" {
+			c.remote("rm -f " + c.pidFile)
+		}
+
+		return c.interruptErr
+	}
+
+	return err
+}
+
+// StdinPipe returns a pipe connected to the command stdin, closing it sends EOF.
+// It must be called before Start, the pipe is closed by Wait.
+func (c *Cmd) StdinPipe() (io.WriteCloser, error) {
+
+	if c.Stdin != nil {
+		return nil, errors.New("goph: Stdin already set")
+	}
+
+	if c.exited != nil {
+		return nil, errors.New("goph: StdinPipe after process started")
+	}
+
+	w, err := c.Session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	pw := &pipeWriter{WriteCloser: w}
+	c.stdinPipe = true
+	c.closeAfterWait = append(c.closeAfterWait, pw)
+	return pw, nil
+}
+
+// StdoutPipe returns a pipe connected to the command stdout.
+// It must be called before Start, the pipe is closed by Wait.
+func (c *Cmd) StdoutPipe() (io.ReadCloser, error) {
+
+	if c.Stdout != nil || c.stdoutLines != nil {
+		return nil, errors.New("goph: Stdout already set")
+	}
+
+	if c.exited != nil {
+		return nil, errors.New("goph: StdoutPipe after process started")
+	}
+
+	r, err := c.Session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.stdoutPipe = true
+
+	pr := &pipeReader{r: r}
+	c.closeAfterWait = append(c.closeAfterWait, pr)
+	return pr, nil
+}
+
+// StderrPipe returns a pipe connected to the command stderr.
+// It must be called before Start, the pipe is closed by Wait.
+func (c *Cmd) StderrPipe() (io.ReadCloser, error) {
+
+	if c.Stderr != nil || c.stderrLines != nil {
+		return nil, errors.New("goph: Stderr already set")
+	}
+
+	if c.exited != nil {
+		return nil, errors.New("goph: StderrPipe after process started")
+	}
+
+	r, err := c.Session.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.stderrPipe = true
+
+	pr := &pipeReader{r: r}
+	c.closeAfterWait = append(c.closeAfterWait, pr)
+	return pr, nil
 }
 
 // String returns the command line string.
 //
-// WARNING: Path and Args are joined as is without any shell escaping.
-// If Path or Args contain untrusted input, remote command injection is possible.
-// Sanitize or shell quote untrusted values yourself before building the Cmd,
-// or use a shell quoting helper from your application.
+// Args are POSIX shell quoted with Quote, so user supplied values cannot break
+// out of the argv. Path is used as is, it may contain shell syntax and must
+// not contain untrusted input. If Raw is set, Args are joined as is too.
 func (c *Cmd) String() string {
-	return fmt.Sprintf("%s %s", c.Path, strings.Join(c.Args, " "))
+
+	if c.Raw {
+		return fmt.Sprintf("%s %s", c.Path, strings.Join(c.Args, " "))
+	}
+
+	if len(c.Args) == 0 {
+		return c.Path
+	}
+
+	return c.Path + " " + QuoteArgs(c.Args...)
+}
+
+// command returns the command line sent to the server: String with the
+// working directory, umask and env exports, run by sudo if set, and the
+// process group prelude if KillProcessGroup is set.
+func (c *Cmd) command() string {
+
+	cmd := c.String()
+
+	if c.Dir != "" {
+		cmd = "cd " + Quote(c.Dir) + " || exit; " + cmd
+	}
+
+	if c.umask != "" {
+		cmd = "umask " + c.umask + "; " + cmd
+	}
+
+	if len(c.envExports) > 0 {
+		cmd = "export " + strings.Join(c.envExports, " ") + "; " + cmd
+	}
+
+	if c.sudo != nil {
+		cmd = c.sudo.wrap(cmd)
+	}
+
+	if c.pidFile == "" {
+		return cmd
+	}
+
+	return fmt.Sprintf("echo $$ > %s; trap 'rm -f %s' EXIT; %s", c.pidFile, c.pidFile, cmd)
 }
 
 // Init inits and sets session env vars.
@@ -88,45 +413,188 @@ func (c *Cmd) init() (err error) {
 		return nil
 	}
 
-	// Set session env vars
-	var env []string
-	for _, value := range c.Env {
-		env = strings.Split(value, "=")
-		if err = c.Setenv(env[0], strings.Join(env[1:], "=")); err != nil {
-			return
+	if c.pty != nil {
+		if err = c.RequestPty(c.pty.term, c.pty.rows, c.pty.cols, c.pty.modes); err != nil {
+			return fmt.Errorf("request pty: %w", err)
+		}
+	}
+
+	if c.KillProcessGroup && c.pidFile == "" {
+
+		token := make([]byte, 8)
+		if _, err = rand.Read(token); err != nil {
+			return err
 		}
+
+		c.pidFile = fmt.Sprintf(`"${TMPDIR:-/tmp}/goph-%x.pid"`, token)
+	}
+
+	// Set session env vars
+	if err = c.setEnv(); err != nil {
+		return
 	}
 
 	c.initialized.Store(true)
 	return nil
 }
 
-// Executes the given callback within session. Sends SIGINT when the context is canceled.
-func (c *Cmd) runInContext(callback func() ([]byte, error)) (out []byte, err error) {
+// start inits the session, calls fn to start the remote command and watches
+// the context until Wait sees the command exit.
+func (c *Cmd) start(fn func() error) error {
+
+	if c.exited != nil {
+		return errors.New("goph: already started")
+	}
+
+	if err := c.init(); err != nil {
+		return fmt.Errorf("cmd init: %w", err)
+	}
+
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	c.setupLines()
 
-	if err = c.init(); err != nil {
-		return nil, fmt.Errorf("cmd init: %w", err)
+	if err := c.setupSudo(); err != nil {
+		return err
 	}
+	c.abort = make(chan struct{})
+
+	started := time.Now()
+	if err := fn(); err != nil {
+		return c.wrapError(err)
+	}
+
+	c.started = started
+	c.exited = make(chan struct{})
+	c.watched = make(chan struct{})
 
-	done := make(chan struct{}, 1)
 	go func() {
-		out, err = callback()
-		done <- struct{}{}
+
+		defer close(c.watched)
+
+		var err error
+
+		select {
+		case <-c.exited:
+			return
+		case <-c.ctx.Done():
+			err = c.ctx.Err()
+		case <-c.abort:
+			err = ErrOutputLimit
+		}
+
+		select {
+		case <-c.exited:
+			return
+		default:
+		}
+
+		c.interruptErr = err
+		c.interrupt(c.exited)
 	}()
 
-	select {
+	return nil
+}
+
+// interrupt runs Cancel or sends CancelSignals, waiting WaitDelay after each
+// step for done, and closes the session if the command is still running.
+func (c *Cmd) interrupt(done <-chan struct{}) {
+
+	delay := c.WaitDelay
+	if delay <= 0 {
+		delay = DefaultWaitDelay
+	}
+
+	var steps []func() error
+	if c.Cancel != nil {
+		steps = append(steps, c.Cancel)
+	} else {
 
-	case <-c.ctx.Done():
+		signals := c.CancelSignals
+		if signals == nil {
+			signals = DefaultCancelSignals
+		}
 
-		if c.Cancel != nil {
-			c.Cancel()
-		} else {
-			c.Signal(ssh.SIGINT)
+		for _, sig := range signals {
+			steps = append(steps, func() error {
+				if c.pidFile != "" {
+					c.killGroup(sig)
+				}
+				return c.Signal(sig)
+			})
 		}
+	}
+
+	for _, step := range steps {
 
-		return nil, c.ctx.Err()
+		step()
 
-	case <-done:
-		return out, err
+		select {
+		case <-done:
+			return
+		case <-time.After(delay):
+		}
 	}
+
+	c.Session.Close()
+}
+
+// killGroup sends sig to the remote process group of the command, or to the
+// recorded process if it is not a group leader.
+func (c *Cmd) killGroup(sig ssh.Signal) error {
+
+	return c.remote(fmt.Sprintf(
+		`pid=$(cat %s 2>/dev/null) && { kill -s %s -- -"$pid" 2>/dev/null || kill -s %s "$pid"; }`,
+		c.pidFile, sig, sig,
+	))
+}
+
+// remote runs cmd in a new session of the command client.
+func (c *Cmd) remote(cmd string) error {
+
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Run(cmd)
+}
+
+// pipeReader is the io.ReadCloser returned by StdoutPipe and StderrPipe,
+// reads after Close return os.ErrClosed.
+type pipeReader struct {
+	r      io.Reader
+	closed atomic.Bool
+}
+
+func (p *pipeReader) Read(b []byte) (int, error) {
+
+	if p.closed.Load() {
+		return 0, os.ErrClosed
+	}
+
+	return p.r.Read(b)
+}
+
+func (p *pipeReader) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+// pipeWriter is the io.WriteCloser returned by StdinPipe and used as the sudo
+// stdin, it is safe to close concurrently with Wait.
+type pipeWriter struct {
+	io.WriteCloser
+	once sync.Once
+	err  error
+}
+
+func (p *pipeWriter) Close() error {
+	p.once.Do(func() {
+		p.err = p.WriteCloser.Close()
+	})
+	return p.err
 }
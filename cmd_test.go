@@ -0,0 +1,51 @@
+package goph
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"":              "''",
+		"simple":        "simple",
+		"/var/log/a.gz": "/var/log/a.gz",
+		"two words":     "'two words'",
+		"it's":          `'it'\''s'`,
+		"$(rm -rf /)":   "'$(rm -rf /)'",
+		"a;b|c&d":       "'a;b|c&d'",
+		"*.txt":         "'*.txt'",
+	}
+
+	for in, want := range tests {
+		if got := Quote(in); got != want {
+			t.Errorf("Quote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCmdString(t *testing.T) {
+	c := &Cmd{Path: "ls", Args: []string{"-la", "my file; rm -rf ~"}}
+	if got, want := c.String(), `ls -la 'my file; rm -rf ~'`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	c.Raw = true
+	if got, want := c.String(), "ls -la my file; rm -rf ~"; got != want {
+		t.Errorf("raw: got %q, want %q", got, want)
+	}
+
+	c = &Cmd{Path: "uptime"}
+	if got := c.String(); got != "uptime" {
+		t.Errorf("no args: got %q", got)
+	}
+}
+
+func TestCmdProcessGroupPrelude(t *testing.T) {
+	c := &Cmd{Path: "sleep", Args: []string{"60"}, pidFile: `"/tmp/goph-1.pid"`}
+	want := `echo $$ > "/tmp/goph-1.pid"; trap 'rm -f "/tmp/goph-1.pid"' EXIT; sleep 60`
+	if got := c.command(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := c.String(); got != "sleep 60" {
+		t.Errorf("String should not include the prelude, got %q", got)
+	}
+}
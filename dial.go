@@ -4,13 +4,19 @@
 package goph
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"net/url"
+	"os"
+	"os/exec"
 	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/net/proxy"
 )
 
 // Dialer holds reusable connection options for establishing SSH connections.
@@ -33,18 +39,38 @@ func (d Dialer) New(user, addr string, opts ...Option) (*Client, error) {
 	return New(user, addr, append(d.opts, opts...)...)
 }
 
+// NewContext is like New but uses ctx to cancel the connection establishment.
+func (d Dialer) NewContext(ctx context.Context, user, addr string, opts ...Option) (*Client, error) {
+	return NewContext(ctx, user, addr, append(d.opts, opts...)...)
+}
+
 // Dial establishes the SSH connection described by c and config.
-// It honors c.User, c.ProxyURL, c.Jump, and c.Port, and
+// It honors c.User, c.ProxyURL, c.ProxyCommand, c.Jump, c.JumpHosts and c.Port, and
 // applies the default known hosts callback if HostKeyCallback is nil.
 //
 // If config.User is empty, it is set from c.User. If c.User is also empty,
 // the current OS user is used, matching OpenSSH default behavior.
 func Dial(c *Client, config *ssh.ClientConfig) error {
+	return DialContext(context.Background(), c, config)
+}
+
+// DialContext is like Dial but uses ctx to cancel the TCP dial, the proxy dial,
+// the jump channel open and the SSH handshake. Once the connection is
+// established, ctx no longer affects the returned client.
+func DialContext(ctx context.Context, c *Client, config *ssh.ClientConfig) error {
 
 	if c.Jump != nil && c.ProxyURL != "" {
 		return fmt.Errorf("goph: cannot use WithProxy and WithJump, put WithProxy on the jump client instead.")
 	}
 
+	if c.ProxyCommand != "" && (c.Jump != nil || c.ProxyURL != "") {
+		return fmt.Errorf("goph: cannot use WithProxyCommand with WithProxy or WithJump.")
+	}
+
+	if len(c.JumpHosts) > 0 && (c.Jump != nil || c.ProxyCommand != "") {
+		return fmt.Errorf("goph: cannot use WithJumpHosts with WithJump or WithProxyCommand.")
+	}
+
 	if config.User == "" {
 		config.User = c.User
 		if config.User == "" {
@@ -70,71 +96,351 @@ func Dial(c *Client, config *ssh.ClientConfig) error {
 	)
 
 	switch {
+	case c.ProxyCommand != "":
+		conn, err = dialProxyCommand(ctx, c)
 	case c.Jump != nil:
-		conn, err = dialJump(c, target)
+		conn, err = dialJump(ctx, c.Jump, target)
+	case len(c.JumpHosts) > 0:
+		conn, err = dialJumpHosts(ctx, c, config, c.JumpHosts, target)
+	case c.proxyJump != "":
+		conn, err = dialJumpHosts(ctx, c, config, ParseJumpHosts(c.proxyJump), target)
 	case c.ProxyURL != "":
-		conn, err = dialProxy(c.ProxyURL, target)
+		conn, err = dialProxy(ctx, c.ProxyURL, c.ProxyTLSConfig, c.ProxyLocalDNS, target)
 	default:
-		conn, err = net.DialTimeout("tcp", target, config.Timeout)
+		d := net.Dialer{Timeout: config.Timeout}
+		conn, err = d.DialContext(ctx, "tcp", target)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	cc, chans, reqs, err := ssh.NewClientConn(conn, target, config)
+	cc, chans, reqs, err := handshake(ctx, conn, target, config)
 	if err != nil {
 		conn.Close()
+		c.closeJumps()
 		return err
 	}
 
-	c.Client = ssh.NewClient(cc, chans, reqs)
+	if pc, ok := conn.(*proxyCommandConn); ok {
+		pc.established()
+	}
+
+	c.config = config
+	c.setClient(ssh.NewClient(&exitConn{Conn: cc}, chans, reqs))
 	return nil
 }
 
-// dialProxy returns a TCP connection to addr through a SOCKS5 proxy.
-func dialProxy(proxyURL, addr string) (net.Conn, error) {
+// handshake runs the SSH handshake over conn, closing conn to unblock it when ctx is done.
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
 
-	u, err := url.Parse(proxyURL)
-	if err != nil {
-		return nil, fmt.Errorf("proxy: invalid proxy URL: %w", err)
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	cc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+
+	if !stop() {
+		if err == nil {
+			cc.Close()
+		}
+		return nil, nil, nil, fmt.Errorf("handshake: %w", context.Cause(ctx))
 	}
 
-	proxyAddr := u.Host
-	if proxyAddr == "" {
-		proxyAddr = proxyURL
+	return cc, chans, reqs, err
+}
+
+// dialJump returns a TCP connection to addr through an existing jump client SSH tunnel.
+func dialJump(ctx context.Context, jump *Client, addr string) (net.Conn, error) {
+
+	conn, err := jump.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("jump dial: %w", err)
 	}
 
-	var auth *proxy.Auth
-	if u.User != nil {
-		auth = &proxy.Auth{
-			User: u.User.Username(),
+	return conn, nil
+}
+
+// dialJumpHosts returns a TCP connection to addr through the jump hosts in order,
+// each hop is tunneled through the previous one.
+// The created jump clients are owned by c and closed with it.
+func dialJumpHosts(ctx context.Context, c *Client, config *ssh.ClientConfig, hops []JumpHost, addr string) (net.Conn, error) {
+
+	var jump *Client
+	for i, jh := range hops {
+
+		hop, hopConfig, err := newJumpHop(c, config, jh)
+		if err != nil {
+			c.closeJumps()
+			return nil, err
+		}
+
+		// The first hop is the one reached through the proxy, if any.
+		if i == 0 && hop.ProxyURL == "" {
+			hop.ProxyURL = c.ProxyURL
+			hop.ProxyTLSConfig = c.ProxyTLSConfig
+			hop.ProxyLocalDNS = c.ProxyLocalDNS
 		}
-		if pass, ok := u.User.Password(); ok {
-			auth.Password = pass
+		hop.Jump = jump
+
+		if err = DialContext(ctx, hop, hopConfig); err != nil {
+			c.closeJumps()
+			return nil, fmt.Errorf("jump %s: %w", jh.Spec, err)
 		}
+
+		c.mu.Lock()
+		c.jumps = append(c.jumps, hop)
+		c.mu.Unlock()
+		jump = hop
 	}
 
-	dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, proxy.Direct)
+	conn, err := dialJump(ctx, jump, addr)
 	if err != nil {
-		return nil, fmt.Errorf("proxy dial: %w", err)
+		c.closeJumps()
+		return nil, err
 	}
 
-	conn, err := dialer.Dial("tcp", addr)
+	return conn, nil
+}
+
+// newJumpHop returns the client and config of a jump host. The hop reuses the
+// auth and host key settings of config and the ssh_config file of c, if any,
+// then the hop options are applied.
+func newJumpHop(c *Client, config *ssh.ClientConfig, jh JumpHost) (*Client, *ssh.ClientConfig, error) {
+
+	user, host, port, err := parseJumpHost(jh.Spec)
 	if err != nil {
-		return nil, fmt.Errorf("proxy dial: %w", err)
+		return nil, nil, err
 	}
 
-	return conn, nil
+	hop := &Client{
+		User: user,
+		Addr: host,
+		Port: 22,
+	}
+
+	hopConfig := &ssh.ClientConfig{
+		Config:          config.Config,
+		User:            user,
+		Auth:            append([]ssh.AuthMethod(nil), config.Auth...),
+		HostKeyCallback: config.HostKeyCallback,
+		BannerCallback:  config.BannerCallback,
+		ClientVersion:   config.ClientVersion,
+		Timeout:         config.Timeout,
+	}
+
+	if c.sshConfig != "" {
+		if err = applySSHConfig(hop, hopConfig, c.sshConfig, true); err != nil {
+			return nil, nil, err
+		}
+		// Hops are chained by the caller, nested ProxyJump is not followed.
+		hop.proxyJump = ""
+	}
+
+	if port != 0 {
+		hop.Port = port
+	}
+
+	for _, opt := range jh.Options {
+		if err = opt(hop, hopConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return hop, hopConfig, nil
 }
 
-// dialJump returns a TCP connection to addr through an existing jump client SSH tunnel.
-func dialJump(c *Client, addr string) (net.Conn, error) {
+// ParseJumpHosts parses a comma separated ProxyJump list into jump hosts.
+func ParseJumpHosts(specs ...string) []JumpHost {
+
+	var hops []JumpHost
+	for _, list := range specs {
+		for _, spec := range strings.Split(list, ",") {
+			if spec = strings.TrimSpace(spec); spec != "" {
+				hops = append(hops, JumpHost{Spec: spec})
+			}
+		}
+	}
+
+	return hops
+}
+
+// parseJumpHost parses a ProxyJump host in the [user@]host[:port] or
+// ssh://[user@]host[:port] form. A zero port means not specified.
+func parseJumpHost(spec string) (user, host string, port uint, err error) {
 
-	conn, err := c.Jump.Dial("tcp", addr)
+	host = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		user, host = host[:i], host[i+1:]
+	}
+
+	if strings.HasPrefix(host, "[") || strings.Count(host, ":") == 1 {
+
+		h, p, err := net.SplitHostPort(host)
+		if err != nil {
+			if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+				return user, strings.Trim(host, "[]"), 0, nil
+			}
+			return "", "", 0, fmt.Errorf("jump: invalid host %q: %w", spec, err)
+		}
+
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("jump: invalid port in %q", spec)
+		}
+
+		host, port = h, uint(n)
+	}
+
+	if host == "" {
+		return "", "", 0, fmt.Errorf("jump: invalid host %q", spec)
+	}
+
+	return user, host, port, nil
+}
+
+// dialProxyCommand starts c.ProxyCommand and returns a connection over its stdin and stdout.
+// The command is killed if ctx is done before the connection is established.
+func dialProxyCommand(ctx context.Context, c *Client) (net.Conn, error) {
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, fmt.Errorf("proxy command: %w", err)
+	}
+
+	// The command context outlives ctx once established is called.
+	cmdCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(cmdCtx, "cmd", "/C", expandSSHTokens(c.ProxyCommand, c, c.Addr, quoteCmd))
+	} else {
+		cmd = exec.CommandContext(cmdCtx, "/bin/sh", "-c", "exec "+expandSSHTokens(c.ProxyCommand, c, c.Addr, Quote))
+	}
+
+	// Like OpenSSH, the command stderr is passed through to ours.
+	cmd.Stderr = os.Stderr
+
+	release := func() {
+		stop()
+		cancel()
+	}
+
+	stdinR, stdinW, err := os.Pipe()
 	if err != nil {
-		return nil, fmt.Errorf("jump dial: %w", err)
+		release()
+		return nil, fmt.Errorf("proxy command: %w", err)
 	}
 
-	return conn, nil
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		release()
+		stdinR.Close()
+		stdinW.Close()
+		return nil, fmt.Errorf("proxy command: %w", err)
+	}
+
+	cmd.Stdin, cmd.Stdout = stdinR, stdoutW
+
+	err = cmd.Start()
+
+	// The child has its own copies now.
+	stdinR.Close()
+	stdoutW.Close()
+
+	if err != nil {
+		release()
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, fmt.Errorf("proxy command: %w", err)
+	}
+
+	return &proxyCommandConn{
+		cmd:     cmd,
+		stdin:   stdinW,
+		stdout:  stdoutR,
+		addr:    proxyCommandAddr(c.ProxyCommand),
+		stop:    stop,
+		release: release,
+	}, nil
+}
+
+// quoteCmd quotes a token of a cmd.exe proxy command, double quotes are
+// dropped since they cannot be escaped.
+func quoteCmd(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+// proxyCommandConn is a net.Conn over the stdin and stdout of a local proxy command.
+// Closing it kills the command.
+type proxyCommandConn struct {
+	cmd     *exec.Cmd
+	stdin   *os.File
+	stdout  *os.File
+	addr    proxyCommandAddr
+	once    sync.Once
+	stop    func() bool
+	release func()
+}
+
+// established detaches the command from the dial context.
+func (p *proxyCommandConn) established() {
+	p.stop()
+}
+
+func (p *proxyCommandConn) Read(b []byte) (int, error) {
+	return p.stdout.Read(b)
+}
+
+func (p *proxyCommandConn) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close closes the pipes, kills the proxy command and waits for it to exit.
+func (p *proxyCommandConn) Close() error {
+
+	p.once.Do(func() {
+		p.stdin.Close()
+		p.stdout.Close()
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+		p.release()
+	})
+
+	return nil
+}
+
+func (p *proxyCommandConn) LocalAddr() net.Addr {
+	return p.addr
+}
+
+func (p *proxyCommandConn) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+func (p *proxyCommandConn) SetDeadline(t time.Time) error {
+	if err := p.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return p.SetWriteDeadline(t)
+}
+
+func (p *proxyCommandConn) SetReadDeadline(t time.Time) error {
+	return p.stdout.SetReadDeadline(t)
+}
+
+func (p *proxyCommandConn) SetWriteDeadline(t time.Time) error {
+	return p.stdin.SetWriteDeadline(t)
+}
+
+// proxyCommandAddr is the net.Addr of a proxy command connection.
+type proxyCommandAddr string
+
+func (a proxyCommandAddr) Network() string {
+	return "proxycommand"
+}
+
+func (a proxyCommandAddr) String() string {
+	return string(a)
 }
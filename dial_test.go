@@ -1,7 +1,12 @@
 package goph
 
 import (
+	"context"
+	"errors"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -29,3 +34,80 @@ func TestDialDefaultsToCurrentUser(t *testing.T) {
 		t.Fatal("expected config.User to be set to current OS user")
 	}
 }
+
+func TestDialContextCancelsHandshake(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Accept connections but never speak SSH, so the handshake stalls.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	c := &Client{User: "admin", Addr: addr.IP.String(), Port: uint(addr.Port)}
+	config := &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = DialContext(ctx, c, config)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// closeConn is an ssh.Conn that records its closing.
+type closeConn struct {
+	ssh.Conn
+	name   string
+	closed *[]string
+	done   chan struct{}
+}
+
+func (c *closeConn) Close() error {
+	*c.closed = append(*c.closed, c.name)
+	close(c.done)
+	return nil
+}
+
+func (c *closeConn) Wait() error {
+	<-c.done
+	return nil
+}
+
+func TestCloseJumpsReverseOrder(t *testing.T) {
+
+	var closed []string
+
+	c := &Client{}
+	for _, name := range []string{"hop1", "hop2", "hop3"} {
+		chans, reqs := make(chan ssh.NewChannel), make(chan *ssh.Request)
+		close(chans)
+		close(reqs)
+
+		conn := &closeConn{name: name, closed: &closed, done: make(chan struct{})}
+		c.jumps = append(c.jumps, &Client{Client: ssh.NewClient(conn, chans, reqs)})
+	}
+
+	if err := c.closeJumps(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(closed, ","); got != "hop3,hop2,hop1" {
+		t.Errorf("hops closed in order %s", got)
+	}
+
+	if c.jumps != nil {
+		t.Error("closed hops should be released")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvMode is how the Env of a Cmd is passed to the remote command.
+//
+// Most servers only accept a few variables with Session.Setenv (OpenSSH
+// AcceptEnv), the prefix modes export the variables in the command line
+// instead, which requires a POSIX login shell. Shell only supports EnvSetenv.
+type EnvMode int
+
+const (
+	// EnvSetenv sets each variable with Session.Setenv, the command fails
+	// if the server rejects one (default).
+	EnvSetenv EnvMode = iota
+
+	// EnvFallback sets each variable with Session.Setenv and exports the
+	// rejected ones in the command line.
+	EnvFallback
+
+	// EnvPrefix always exports the variables in the command line.
+	EnvPrefix
+)
+
+// setEnv passes Env to the remote command according to EnvMode.
+func (c *Cmd) setEnv() error {
+
+	for _, value := range c.Env {
+
+		name, val, _ := strings.Cut(value, "=")
+
+		if c.EnvMode != EnvPrefix {
+
+			err := c.Setenv(name, val)
+			if err == nil {
+				continue
+			}
+
+			if c.EnvMode == EnvSetenv {
+				return err
+			}
+		}
+
+		if !isEnvName(name) {
+			return fmt.Errorf("goph: invalid env name %q", name)
+		}
+
+		c.envExports = append(c.envExports, name+"="+Quote(val))
+	}
+
+	return nil
+}
+
+// isEnvName reports whether name is a valid POSIX shell variable name.
+func isEnvName(name string) bool {
+
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+
+	for _, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,19 @@
+package goph
+
+import (
+	"testing"
+)
+
+func TestEnvExports(t *testing.T) {
+
+	c := &Cmd{Path: "echo", Args: []string{"$FOO"}, envExports: []string{"FOO=" + Quote("a b"), "BAR=1"}}
+	if got, want := c.command(), `export FOO='a b' BAR=1; echo '$FOO'`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for name, want := range map[string]bool{"FOO": true, "_x1": true, "1X": false, "A-B": false, "": false, "A B": false} {
+		if got := isEnvName(name); got != want {
+			t.Errorf("isEnvName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// stderrTailSize is the number of trailing stderr bytes kept in ExitError.Stderr.
+const stderrTailSize = 32 << 10
+
+var (
+	// ErrCommandFailed matches, with errors.Is, the *ExitError of a remote
+	// command that exited with a non zero status or was killed by a signal.
+	ErrCommandFailed = errors.New("goph: command failed")
+
+	// ErrConnectionLost matches, with errors.Is, the error of a remote command
+	// whose session ended without an exit status, usually because the
+	// connection was lost.
+	//
+	// A command stopped because its context is done returns an error that
+	// matches context.Canceled or context.DeadlineExceeded instead.
+	ErrConnectionLost = errors.New("goph: connection lost")
+
+	// ErrOutputLimit is returned when a command is aborted because its
+	// output exceeds the OutputLimit, see OutputLimit.Abort.
+	ErrOutputLimit = errors.New("goph: output limit exceeded")
+)
+
+// ExitError is returned when a remote command exits with a non zero status
+// or is killed by a signal.
+type ExitError struct {
+
+	// Code is the exit status, -1 if the command was killed by a signal.
+	Code int
+
+	// Signal is the signal name without the SIG prefix (e.g. "KILL"),
+	// empty if the command exited normally.
+	Signal string
+
+	// CoreDumped reports whether the process killed by Signal dumped core.
+	CoreDumped bool
+
+	// Cmd is the command line that was run.
+	Cmd string
+
+	// Host is the address of the remote host.
+	Host string
+
+	// Duration is the time elapsed from the start of the command to its exit.
+	Duration time.Duration
+
+	// Stderr holds the tail of the standard error output when it was not
+	// redirected, like os/exec.ExitError.Stderr, set by Cmd.Output only.
+	Stderr []byte
+
+	// Err is the underlying *ssh.ExitError.
+	Err *ssh.ExitError
+}
+
+// Error returns a description of the failure.
+func (e *ExitError) Error() string {
+
+	if e.CoreDumped {
+		return fmt.Sprintf("goph: %q on %s: killed by signal %s (core dumped)", e.Cmd, e.Host, e.Signal)
+	}
+
+	if e.Signal != "" {
+		return fmt.Sprintf("goph: %q on %s: killed by signal %s", e.Cmd, e.Host, e.Signal)
+	}
+
+	return fmt.Sprintf("goph: %q on %s: exit status %d", e.Cmd, e.Host, e.Code)
+}
+
+// Unwrap returns the underlying *ssh.ExitError.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrCommandFailed.
+func (e *ExitError) Is(target error) bool {
+	return target == ErrCommandFailed
+}
+
+// ExitCode returns the exit status, -1 if the command was killed by a signal.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// wrapError converts the session error of a command into an *ExitError or
+// an ErrConnectionLost error, other errors are returned as is.
+func (c *Cmd) wrapError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+
+		e := &ExitError{
+			Code:     exitErr.ExitStatus(),
+			Signal:   exitErr.Signal(),
+			Cmd:      c.String(),
+			Duration: time.Since(c.started),
+			Err:      exitErr,
+		}
+
+		if e.Signal != "" {
+			e.Code = -1
+			if c.exit != nil {
+				e.CoreDumped = c.exit.coreDumped.Load()
+			}
+		}
+
+		if c.client != nil {
+			e.Host = c.client.Addr
+		}
+
+		if c.stderrTail != nil {
+			e.Stderr = c.stderrTail.Bytes()
+		}
+
+		return e
+	}
+
+	var missingErr *ssh.ExitMissingError
+	if errors.As(err, &missingErr) || errors.Is(err, io.EOF) || (c.client != nil && c.client.Err() != nil) {
+		return fmt.Errorf("%w: %w", ErrConnectionLost, err)
+	}
+
+	return err
+}
+
+// tailBuffer is an io.Writer that keeps the last max bytes written.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+
+	n := len(p)
+	if len(p) > t.max {
+		p = p[len(p)-t.max:]
+	}
+
+	if over := len(t.buf) + len(p) - t.max; over > 0 {
+		t.buf = t.buf[over:]
+	}
+
+	t.buf = append(t.buf, p...)
+	return n, nil
+}
+
+// Bytes returns the kept bytes.
+func (t *tailBuffer) Bytes() []byte {
+	return t.buf
+}
+
+// exitConn is an ssh.Conn whose session channels keep the core dumped flag
+// of the exit-signal request, which ssh.Session drops.
+type exitConn struct {
+	ssh.Conn
+
+	// mu serializes the session opens of Client.newSession.
+	mu sync.Mutex
+
+	// opened is the last session channel opened.
+	opened atomic.Pointer[exitChannel]
+}
+
+func (c *exitConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+
+	ch, in, err := c.Conn.OpenChannel(name, data)
+	if err != nil || name != "session" {
+		return ch, in, err
+	}
+
+	ec := &exitChannel{Channel: ch}
+	out := make(chan *ssh.Request)
+
+	go func() {
+		defer close(out)
+		for req := range in {
+			if req.Type == "exit-signal" {
+				var sig struct {
+					Signal     string
+					CoreDumped bool
+					Error      string
+					Lang       string
+				}
+				if ssh.Unmarshal(req.Payload, &sig) == nil {
+					ec.coreDumped.Store(sig.CoreDumped)
+				}
+			}
+			out <- req
+		}
+	}()
+
+	c.opened.Store(ec)
+	return ec, out, nil
+}
+
+// exitChannel is a session channel of exitConn.
+type exitChannel struct {
+	ssh.Channel
+	coreDumped atomic.Bool
+}
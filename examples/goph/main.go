@@ -35,6 +35,9 @@ import (
 // Run a command and interrupt it after 1 second:
 // > go run main.go --ip 192.168.122.102 --cmd "sleep 10" --timeout=1s
 //
+// Open a real interactive shell:
+// > go run main.go --ip 192.168.122.102 --shell
+//
 // You can test with the interactive mode without passing --cmd flag.
 //
 
@@ -50,6 +53,7 @@ var (
 	passphrase bool
 	timeout    time.Duration
 	agent      bool
+	shell      bool
 	sftpc      *sftp.Client
 )
 
@@ -68,6 +72,7 @@ func init() {
 	flag.BoolVar(&pass, "pass", false, "ask for ssh password instead of private key.")
 	flag.BoolVar(&agent, "agent", false, "use ssh agent for authentication (unix systems only).")
 	flag.BoolVar(&passphrase, "passphrase", false, "ask for private key passphrase.")
+	flag.BoolVar(&shell, "shell", false, "open an interactive remote shell.")
 	flag.DurationVar(&timeout, "timeout", 0, "interrupt a command with SIGINT after a given timeout (0 means no timeout)")
 }
 
@@ -148,6 +153,14 @@ func main() {
 		return
 	}
 
+	// Open a remote login shell with a pty.
+	if shell {
+		if err := client.Shell(context.Background()); err != nil {
+			fmt.Println("shell:", err)
+		}
+		return
+	}
+
 	// else open interactive mode.
 	playWithSSHJustForTestingThisProgram(client)
 }
@@ -286,6 +299,9 @@ loop:
 			if err != nil {
 				panic(err)
 			}
+
+			// The typed line is shell syntax, run it as is.
+			command.Raw = true
 			out, err = command.CombinedOutput()
 			fmt.Println(string(out), err)
 		}
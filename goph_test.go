@@ -1,13 +1,25 @@
 package goph_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/melbahja/goph/v2"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -58,6 +70,23 @@ func TestGoph(t *testing.T) {
 	t.Run("gophRunTest", gophRunTest)
 	t.Run("gophAuthTest", gophAuthTest)
 	t.Run("gophWrongPassTest", gophWrongPassTest)
+	t.Run("gophKeepAliveTest", gophKeepAliveTest)
+	t.Run("gophCloseReasonTest", gophCloseReasonTest)
+	t.Run("gophReconnectTest", gophReconnectTest)
+	t.Run("gophPoolTest", gophPoolTest)
+	t.Run("gophExitErrorTest", gophExitErrorTest)
+	t.Run("gophPtyTest", gophPtyTest)
+	t.Run("gophCancelTest", gophCancelTest)
+	t.Run("gophKillProcessGroupTest", gophKillProcessGroupTest)
+	t.Run("gophPipeTest", gophPipeTest)
+	t.Run("gophOutputLimitTest", gophOutputLimitTest)
+	t.Run("gophEnvTest", gophEnvTest)
+	t.Run("gophTransferDirTest", gophTransferDirTest)
+	t.Run("gophProxyCommandTest", gophProxyCommandTest)
+	t.Run("gophJumpHostsTest", gophJumpHostsTest)
+	t.Run("gophShellTest", gophShellTest)
+	t.Run("gophScriptUploadTest", gophScriptUploadTest)
+	t.Run("gophSudoTest", gophSudoTest)
 }
 
 func gophAuthTest(t *testing.T) {
@@ -111,13 +140,1091 @@ func gophWrongPassTest(t *testing.T) {
 	}
 }
 
+func gophKeepAliveTest(t *testing.T) {
+
+	newStalledServer("2023")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2023),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithKeepAlive(50*time.Millisecond, 2),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("keepalive should close the client")
+	}
+
+	if err = client.Wait(); !errors.Is(err, goph.ErrKeepAliveTimeout) {
+		t.Errorf("expected ErrKeepAliveTimeout, got %v", err)
+	}
+}
+
+func gophCloseReasonTest(t *testing.T) {
+
+	newServer("2025")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2025),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+
+	if client.Err() != nil {
+		t.Errorf("open client should not have a close reason, got %v", client.Err())
+	}
+
+	client.Close()
+
+	if err = client.Wait(); !errors.Is(err, goph.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func gophReconnectTest(t *testing.T) {
+
+	newServer("2026")
+
+	events := make(chan goph.ReconnectEvent, 1)
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2026),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithReconnect(goph.ReconnectPolicy{
+			InitialDelay: 10 * time.Millisecond,
+			OnEvent: func(e goph.ReconnectEvent) {
+				events <- e
+			},
+		}),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	// Ask the server to drop the connection.
+	client.SendRequest("goph-test-drop", false, nil)
+
+	select {
+	case e := <-events:
+		if e.Err != nil || e.Attempt != 1 {
+			t.Fatalf("unexpected reconnect event: %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client should reconnect")
+	}
+
+	if _, err = client.Run("ls"); err != nil {
+		t.Errorf("run after reconnect error: %s", err)
+	}
+
+	if client.Err() != nil {
+		t.Errorf("reconnected client should not be done, got %v", client.Err())
+	}
+}
+
+func gophPoolTest(t *testing.T) {
+
+	newServer("2027")
+
+	pool := goph.NewPool(
+		goph.NewDialer(
+			goph.WithPassword("123456"),
+			goph.WithPort(2027),
+			goph.WithInsecureIgnoreHostKey(),
+		),
+		goph.WithPoolMaxSessions(2),
+	)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	c1, release1, err := pool.Get(ctx, "melbahja", "127.0.10.10")
+	if err != nil {
+		t.Fatalf("pool get error: %s", err)
+	}
+
+	c2, release2, err := pool.Get(ctx, "melbahja", "127.0.10.10")
+	if err != nil {
+		t.Fatalf("pool get error: %s", err)
+	}
+
+	if c1 != c2 {
+		t.Error("expected the same client for the same key")
+	}
+
+	// Both session slots are leased, a new connection is dialed.
+	c3, release3, err := pool.Get(ctx, "melbahja", "127.0.10.10")
+	if err != nil {
+		t.Fatalf("pool get error: %s", err)
+	}
+
+	if c3 == c1 {
+		t.Error("expected a new client when the session limit is reached")
+	}
+
+	release1()
+	release2()
+	release3()
+
+	if _, err = pool.Run(ctx, "melbahja", "127.0.10.10", "ls"); err != nil {
+		t.Errorf("pool run error: %s", err)
+	}
+
+	// A session refused again after the retry is returned to the caller.
+	var openErr *ssh.OpenChannelError
+	if _, err = pool.Run(ctx, "nosession", "127.0.10.10", "ls"); !errors.As(err, &openErr) || openErr.Reason != ssh.Prohibited {
+		t.Errorf("expected a prohibited OpenChannelError, got %v", err)
+	}
+
+	pool.Close()
+
+	if err = c1.Wait(); !errors.Is(err, goph.ErrClosed) {
+		t.Errorf("expected pooled client to be closed, got %v", err)
+	}
+
+	if _, _, err = pool.Get(ctx, "melbahja", "127.0.10.10"); !errors.Is(err, goph.ErrPoolClosed) {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+
+	// Custom auth methods are only pooled with a key, different
+	// credentials never share a client.
+	pool = goph.NewPool(goph.NewDialer(goph.WithPort(2027), goph.WithInsecureIgnoreHostKey()))
+	defer pool.Close()
+
+	if _, _, err = pool.Get(ctx, "melbahja", "127.0.10.10", goph.WithAuth(ssh.Password("123456"))); !errors.Is(err, goph.ErrPoolKey) {
+		t.Errorf("expected ErrPoolKey, got %v", err)
+	}
+
+	c1, release1, err = pool.Get(ctx, "melbahja", "127.0.10.10", goph.WithAuth(ssh.Password("123456")), goph.WithPoolKey("alice"))
+	if err != nil {
+		t.Fatalf("pool get error: %s", err)
+	}
+	defer release1()
+
+	c2, release2, err = pool.Get(ctx, "melbahja", "127.0.10.10", goph.WithAuth(ssh.Password("123456")), goph.WithPoolKey("alice"))
+	if err != nil {
+		t.Fatalf("pool get error: %s", err)
+	}
+	defer release2()
+
+	if c1 != c2 {
+		t.Error("expected the same client for the same pool key")
+	}
+
+	if _, _, err = pool.Get(ctx, "melbahja", "127.0.10.10", goph.WithAuth(ssh.Password("wrong")), goph.WithPoolKey("mallory")); err == nil {
+		t.Error("expected an auth error with other credentials")
+	}
+}
+
+func gophExitErrorTest(t *testing.T) {
+
+	newServer("2028")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2028),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	_, err = client.Run("exit 3")
+
+	if !errors.Is(err, goph.ErrCommandFailed) {
+		t.Fatalf("expected ErrCommandFailed, got %v", err)
+	}
+
+	var exitErr *goph.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected *goph.ExitError, got %T", err)
+	}
+
+	if exitErr.Code != 3 || exitErr.Host != "127.0.10.10" || exitErr.Cmd != "exit 3" {
+		t.Errorf("unexpected exit error: %+v", exitErr)
+	}
+
+	if errors.Is(err, goph.ErrConnectionLost) {
+		t.Error("exit error should not match ErrConnectionLost")
+	}
+
+	if exitErr.Signal != "" || exitErr.CoreDumped {
+		t.Errorf("exit status error should not have a signal: %+v", exitErr)
+	}
+
+	_, err = client.Run("segv")
+
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected *goph.ExitError, got %T", err)
+	}
+
+	if exitErr.Signal != "SEGV" || !exitErr.CoreDumped || exitErr.Code != -1 {
+		t.Errorf("unexpected signal exit error: %+v", exitErr)
+	}
+
+	if !strings.HasSuffix(err.Error(), "killed by signal SEGV (core dumped)") {
+		t.Errorf("unexpected error message %q", err)
+	}
+}
+
+func gophPtyTest(t *testing.T) {
+
+	newServer("2029")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2029),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	cmd, err := client.Command("top", "-b")
+	if err != nil {
+		t.Fatalf("command error: %s", err)
+	}
+
+	if err = cmd.Resize(80, 24); err == nil {
+		t.Error("resize without pty should return an error")
+	}
+
+	cmd.Apply(goph.WithPty("xterm-256color", 120, 40, ssh.TerminalModes{ssh.ECHO: 0}))
+
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("start with pty error: %s", err)
+	}
+
+	if err = cmd.Resize(100, 30); err != nil {
+		t.Errorf("resize error: %s", err)
+	}
+
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("wait error: %s", err)
+	}
+}
+
+func gophCancelTest(t *testing.T) {
+
+	newServer("2030")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2030),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name    string
+		opts    []goph.CmdOption
+		signals string
+	}{
+		{"escalate", nil, "INT\nTERM\nKILL\n"},
+		{"close session", []goph.CmdOption{goph.WithCancelSignals(ssh.SIGINT)}, "INT\n"},
+	}
+
+	for _, tt := range tests {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		cmd, err := client.CommandContext(ctx, "hang")
+		if err != nil {
+			t.Fatalf("%s: command error: %s", tt.name, err)
+		}
+
+		var stderr bytes.Buffer
+		cmd.Apply(goph.WithWaitDelay(50*time.Millisecond), goph.WithStderr(&stderr))
+		cmd.Apply(tt.opts...)
+
+		start := time.Now()
+		if err = cmd.Run(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected context.DeadlineExceeded, got %v", tt.name, err)
+		}
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: cancellation took %s", tt.name, elapsed)
+		}
+
+		if stderr.String() != tt.signals {
+			t.Errorf("%s: expected signals %q, got %q", tt.name, tt.signals, stderr.String())
+		}
+	}
+}
+
+func gophKillProcessGroupTest(t *testing.T) {
+
+	newServer("2031")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2031),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cmd, err := client.CommandContext(ctx, "hang")
+	if err != nil {
+		t.Fatalf("command error: %s", err)
+	}
+
+	// The server ignores the signal requests, only the group kill stops
+	// the command before the session is closed after WaitDelay.
+	cmd.Apply(
+		goph.WithKillProcessGroup(),
+		goph.WithCancelSignals(ssh.SIGKILL),
+		goph.WithWaitDelay(5*time.Second),
+	)
+
+	start := time.Now()
+	if err = cmd.Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("process group kill did not stop the command, took %s", elapsed)
+	}
+}
+
+func gophPipeTest(t *testing.T) {
+
+	newServer("2032")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2032),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	cmd, err := client.Command("cat")
+	if err != nil {
+		t.Fatalf("command error: %s", err)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("stdin pipe error: %s", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("stdout pipe error: %s", err)
+	}
+
+	if err = cmd.Wait(); err == nil {
+		t.Error("wait before start should return an error")
+	}
+
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("start error: %s", err)
+	}
+
+	if _, err = cmd.StderrPipe(); err == nil {
+		t.Error("stderr pipe after start should return an error")
+	}
+
+	// The test server prompts "> " and reads lines until stdin is closed.
+	stdin.Write([]byte("hello\n"))
+	stdin.Close()
+
+	out, err := io.ReadAll(stdout)
+	if err != nil || !strings.HasPrefix(string(out), "> ") {
+		t.Errorf("unexpected stdout %q, error: %v", out, err)
+	}
+
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("wait error: %s", err)
+	}
+
+	if err = cmd.Wait(); err == nil {
+		t.Error("second wait should return an error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cmd, err = client.CommandContext(ctx, "hang")
+	if err != nil {
+		t.Fatalf("command error: %s", err)
+	}
+	cmd.Apply(goph.WithWaitDelay(50 * time.Millisecond))
+
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("start error: %s", err)
+	}
+
+	if err = cmd.Wait(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Without a sudo password, there is no prompt and stderr can be a pipe.
+	cmd, err = client.Command("id")
+	if err != nil {
+		t.Fatalf("command error: %s", err)
+	}
+	cmd.Apply(goph.WithUser("deploy"))
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatalf("stderr pipe error: %s", err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("start with user and stderr pipe error: %s", err)
+	}
+
+	go io.Copy(io.Discard, stderr)
+
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("wait error: %s", err)
+	}
+}
+
+func gophOutputLimitTest(t *testing.T) {
+
+	newServer("2033")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2033),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithDefaultOutputLimit(goph.OutputLimit{Head: 1, Abort: true}),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	cmd, err := client.Command("hang")
+	if err != nil {
+		t.Fatalf("command error: %s", err)
+	}
+	cmd.Apply(goph.WithWaitDelay(50 * time.Millisecond))
+
+	// The test server prompt "> " exceeds the limit and aborts the command.
+	out, err := cmd.CombinedOutput()
+	if !errors.Is(err, goph.ErrOutputLimit) {
+		t.Errorf("expected ErrOutputLimit, got %v", err)
+	}
+
+	var truncErr *goph.TruncatedError
+	if !cmd.Truncated() || !errors.As(err, &truncErr) || string(out) != ">" || truncErr.Offset != 1 {
+		t.Errorf("expected truncated output, got %q, %v", out, err)
+	}
+}
+
+func gophEnvTest(t *testing.T) {
+
+	newServer("2034")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2034),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	// The test server rejects all env requests, like a default AcceptEnv.
+	for mode, fails := range map[goph.EnvMode]bool{goph.EnvSetenv: true, goph.EnvFallback: false, goph.EnvPrefix: false} {
+
+		cmd, err := client.Command("true")
+		if err != nil {
+			t.Fatalf("command error: %s", err)
+		}
+
+		cmd.Apply(goph.WithEnv("FOO=bar"), goph.WithEnvMode(mode))
+
+		if err = cmd.Run(); (err != nil) != fails {
+			t.Errorf("env mode %d: unexpected error: %v", mode, err)
+		}
+	}
+}
+
+func gophTransferDirTest(t *testing.T) {
+
+	newServer("2035")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2035),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	// The test server sftp subsystem serves the local file system.
+	local, remote := t.TempDir(), t.TempDir()
+
+	os.MkdirAll(filepath.Join(local, "a"), 0755)
+	os.MkdirAll(filepath.Join(local, "skip"), 0755)
+	os.WriteFile(filepath.Join(local, "top.txt"), []byte("top"), 0644)
+	os.WriteFile(filepath.Join(local, "a", "b.txt"), []byte("b"), 0640)
+	os.WriteFile(filepath.Join(local, "a", "c.log"), []byte("c"), 0644)
+	os.WriteFile(filepath.Join(local, "skip", "x.txt"), []byte("x"), 0644)
+	os.Symlink("top.txt", filepath.Join(local, "link"))
+
+	if err = client.UploadDir(local, remote, goph.WithExclude("skip", "*.log")); err != nil {
+		t.Fatalf("upload dir error: %s", err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(remote, "a", "b.txt")); err != nil || string(b) != "b" {
+		t.Errorf("unexpected a/b.txt %q, error: %v", b, err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(remote, "a", "b.txt")); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("a/b.txt mode was not kept: %v", err)
+	}
+
+	for _, name := range []string{"a/c.log", "skip"} {
+		if _, err := os.Stat(filepath.Join(remote, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be excluded", name)
+		}
+	}
+
+	if target, err := os.Readlink(filepath.Join(remote, "link")); err != nil || target != "top.txt" {
+		t.Errorf("link should be preserved, got %q, error: %v", target, err)
+	}
+
+	downloaded := t.TempDir()
+	err = client.DownloadDir(remote, downloaded, goph.WithSymlinks(goph.SymlinkFollow), goph.WithInclude("*.txt"))
+	if err != nil {
+		t.Fatalf("download dir error: %s", err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(downloaded, "a", "b.txt")); err != nil || string(b) != "b" {
+		t.Errorf("unexpected downloaded a/b.txt %q, error: %v", b, err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(downloaded, "link")); !os.IsNotExist(err) {
+		t.Error("link should not match the include pattern")
+	}
+
+	var last goph.Progress
+	reports := 0
+	onProgress := goph.WithProgress(func(p goph.Progress) {
+		last = p
+		reports++
+	}, time.Hour)
+
+	if err = client.Upload(filepath.Join(local, "a", "b.txt"), filepath.Join(remote, "single"), onProgress); err != nil {
+		t.Fatalf("upload error: %s", err)
+	}
+
+	if !last.Done || last.Bytes != 1 || last.Total != 1 {
+		t.Errorf("unexpected upload progress %+v", last)
+	}
+
+	reports = 0
+	if err = client.DownloadDir(remote, t.TempDir(), onProgress); err != nil {
+		t.Fatalf("download dir error: %s", err)
+	}
+
+	// top.txt, a/b.txt and single, the first and the last reports.
+	if !last.Done || last.Bytes != 5 || last.Total != 5 || reports != 2 {
+		t.Errorf("unexpected download dir progress %+v after %d reports", last, reports)
+	}
+
+	// Resume appends the rest of partial files, verify restarts them if
+	// their prefix differs.
+	src := filepath.Join(local, "resume")
+	os.WriteFile(src, []byte("0123456789"), 0644)
+
+	tests := []struct {
+		partial string
+		verify  bool
+		want    string
+	}{
+		{"01234", false, "0123456789"},
+		{"xx", false, "xx23456789"},
+		{"xx", true, "0123456789"},
+		{"0123456789abc", false, "0123456789"},
+	}
+
+	for _, tt := range tests {
+
+		dst := filepath.Join(remote, "resume")
+		os.WriteFile(dst, []byte(tt.partial), 0644)
+
+		if err = client.Upload(src, dst, goph.WithResume(tt.verify)); err != nil {
+			t.Fatalf("resume upload error: %s", err)
+		}
+
+		if b, _ := os.ReadFile(dst); string(b) != tt.want {
+			t.Errorf("upload %q verify %v: got %q, want %q", tt.partial, tt.verify, b, tt.want)
+		}
+
+		dst = filepath.Join(t.TempDir(), "resume")
+		os.WriteFile(dst, []byte(tt.partial), 0644)
+
+		if err = client.Download(src, dst, goph.WithResume(tt.verify), onProgress); err != nil {
+			t.Fatalf("resume download error: %s", err)
+		}
+
+		if b, _ := os.ReadFile(dst); string(b) != tt.want {
+			t.Errorf("download %q verify %v: got %q, want %q", tt.partial, tt.verify, b, tt.want)
+		}
+
+		if last.Bytes != 10 || last.Total != 10 {
+			t.Errorf("resumed progress should count the whole file, got %+v", last)
+		}
+	}
+
+	// Atomic uploads replace the target keeping its mode, without leftovers.
+	atomicDir := t.TempDir()
+	dst := filepath.Join(atomicDir, "atomic")
+	os.WriteFile(dst, []byte("old content"), 0600)
+
+	if err = client.Upload(src, dst, goph.WithAtomic()); err != nil {
+		t.Fatalf("atomic upload error: %s", err)
+	}
+
+	if b, _ := os.ReadFile(dst); string(b) != "0123456789" {
+		t.Errorf("atomic upload: got %q", b)
+	}
+
+	if fi, err := os.Stat(dst); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("atomic upload should keep the mode, got %v, error: %v", fi.Mode(), err)
+	}
+
+	if err = client.Upload(src, dst, goph.WithAtomic(), goph.WithMode(0640)); err != nil {
+		t.Fatalf("atomic upload error: %s", err)
+	}
+
+	if fi, err := os.Stat(dst); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("atomic upload should set the mode, got %v, error: %v", fi.Mode(), err)
+	}
+
+	if err = client.Upload(filepath.Join(local, "missing"), dst, goph.WithAtomic()); err == nil {
+		t.Error("expected an error uploading a missing file")
+	}
+
+	if entries, _ := os.ReadDir(atomicDir); len(entries) != 1 {
+		t.Errorf("expected only the target in the directory, got %v", entries)
+	}
+
+	// A symlink loop is reported without aborting the transfer.
+	os.Symlink(".", filepath.Join(local, "loop"))
+
+	followed := t.TempDir()
+	err = client.UploadDir(local, followed, goph.WithSymlinks(goph.SymlinkFollow))
+
+	var errs goph.TransferErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "loop" {
+		t.Errorf("expected a loop transfer error, got %v", err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(followed, "link")); err != nil || string(b) != "top" {
+		t.Errorf("link should be followed, got %q, error: %v", b, err)
+	}
+
+	var fileErr *goph.TransferError
+	err = client.UploadDir(local, t.TempDir(), goph.WithSymlinks(goph.SymlinkFollow), goph.WithFailFast())
+	if !errors.As(err, &fileErr) || errors.As(err, &errs) {
+		t.Errorf("expected a single *goph.TransferError, got %v", err)
+	}
+}
+
+func gophShellTest(t *testing.T) {
+
+	newServer("2041")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2041),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	w.Write([]byte("exit 3\n"))
+
+	var out bytes.Buffer
+	err = client.Shell(context.Background(), goph.WithStdout(&out), goph.WithStderr(io.Discard))
+
+	var exitErr *goph.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+
+	if !strings.Contains(out.String(), "exit 3") {
+		t.Errorf("expected the echoed input, got %q", out.String())
+	}
+
+	// Shell returned without waiting for stdin and no longer reads it.
+	w.Write([]byte("next\n"))
+	w.Close()
+
+	if b, _ := io.ReadAll(r); string(b) != "next\n" {
+		t.Errorf("the input after the shell exited was consumed, got %q", b)
+	}
+}
+
+func gophScriptUploadTest(t *testing.T) {
+
+	newServer("2042")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2042),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	// The test server sftp subsystem serves the local file system.
+	dir := t.TempDir()
+	script := func(ctx context.Context) *goph.Cmd {
+
+		cmd, err := client.Script(ctx, strings.NewReader("#!/bin/bash -e\necho hi\n"),
+			goph.WithScriptUpload(), goph.WithScriptDir(dir), goph.WithShebang())
+
+		if err != nil {
+			t.Fatalf("script error: %s", err)
+		}
+
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Fatalf("expected the uploaded script in %s, got %v", dir, entries)
+		}
+
+		return cmd
+	}
+
+	assertRemoved := func(when string) {
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("the script was not removed %s, got %v", when, entries)
+		}
+	}
+
+	// The file is run with its interpreter, not executed.
+	cmd := script(context.Background())
+	if s := cmd.String(); !strings.HasPrefix(s, "/bin/bash -e "+dir+"/goph-script-") {
+		t.Errorf("unexpected command %q", s)
+	}
+
+	if err = cmd.Run(); err != nil {
+		t.Errorf("run error: %s", err)
+	}
+	assertRemoved("by Wait")
+
+	script(context.Background()).Close()
+	assertRemoved("by Close")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd = script(ctx)
+	cancel()
+
+	if err = cmd.Start(); err == nil {
+		t.Error("expected a start error with a canceled context")
+	}
+	assertRemoved("by a failed Start")
+}
+
+func gophSudoTest(t *testing.T) {
+
+	newServer("2043")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2043),
+		goph.WithInsecureIgnoreHostKey(),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer client.Close()
+
+	calls := 0
+	sudo := func(answer string) ([]byte, error) {
+		return client.Sudo(context.Background(), "id -un", func() (string, error) {
+			calls++
+			return answer, nil
+		})
+	}
+
+	sudoPassword.Store("first")
+
+	// The first call prompts.
+	out, err := sudo("first")
+	if err != nil || string(out) != "root\n" {
+		t.Fatalf("sudo error: %v, output %q", err, out)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the password callback to be called once, got %d", calls)
+	}
+
+	// The cached password is used without calling the callback.
+	if _, err = sudo("unused"); err != nil {
+		t.Fatalf("sudo with the cached password error: %s", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the cached password to be used, the callback was called %d times", calls)
+	}
+
+	// A rejected cached password calls the callback again.
+	sudoPassword.Store("second")
+
+	if out, err = sudo("second"); err != nil || string(out) != "root\n" {
+		t.Fatalf("sudo after a password change error: %v, output %q", err, out)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected the callback to be called again, got %d calls", calls)
+	}
+
+	if _, err = sudo("unused"); err != nil || calls != 2 {
+		t.Errorf("expected the new password to be cached, got %v after %d calls", err, calls)
+	}
+
+	// A wrong password is a SudoError.
+	sudoPassword.Store("third")
+
+	if _, err = sudo("wrong"); !errors.Is(err, goph.ErrSudoPassword) {
+		t.Errorf("expected ErrSudoPassword, got %v", err)
+	}
+}
+
+// TestProxyCommandHelper is run as a proxy command by gophProxyCommandTest,
+// it connects its stdin and stdout to the host and port args like nc.
+func TestProxyCommandHelper(t *testing.T) {
+
+	if os.Getenv("GOPH_PROXY_HELPER") != "1" {
+		return
+	}
+
+	args := os.Args[len(os.Args)-2:]
+	conn, err := net.Dial("tcp", net.JoinHostPort(args[0], args[1]))
+	if err != nil {
+		os.Exit(1)
+	}
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		conn.Close()
+	}()
+
+	io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
+
+func gophProxyCommandTest(t *testing.T) {
+
+	newServer("2036")
+
+	t.Setenv("GOPH_PROXY_HELPER", "1")
+	helper := goph.Quote(os.Args[0]) + " -test.run=TestProxyCommandHelper -- %h %p"
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2036),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithProxyCommand(helper),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+
+	if _, err = client.Run("ls"); err != nil {
+		t.Errorf("run error: %s", err)
+	}
+	client.Close()
+
+	// The expanded host is a single quoted arg, not shell code.
+	marker := filepath.Join(t.TempDir(), "injected")
+	_, err = goph.New("melbahja", "127.0.10.10; touch "+marker,
+		goph.WithPassword("123456"),
+		goph.WithPort(2036),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithProxyCommand(helper),
+	)
+
+	if err == nil {
+		t.Error("expected an error with an invalid host")
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the proxy command host was run by the shell")
+	}
+
+	// The dial context kills a command that never connects.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = goph.NewContext(ctx, "melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2036),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithProxyCommand("sleep 10"),
+	)
+
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("expected a deadline exceeded error, got %v after %s", err, time.Since(start))
+	}
+}
+
+func gophJumpHostsTest(t *testing.T) {
+
+	newServer("2037")
+	newServer("2038")
+	newServer("2039")
+
+	client, err := goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2039),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithJumpHosts("melbahja@127.0.10.10:2037"),
+		goph.WithJumpHost("melbahja@127.0.10.10", goph.WithPort(2038)),
+	)
+
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+
+	if _, err = client.Run("ls"); err != nil {
+		t.Errorf("run error: %s", err)
+	}
+
+	for _, tunnel := range []string{"127.0.10.10:2037 -> 127.0.10.10:2038", "127.0.10.10:2038 -> 127.0.10.10:2039"} {
+		if _, ok := tunnels.Load(tunnel); !ok {
+			t.Errorf("expected the tunnel %s", tunnel)
+		}
+	}
+
+	if err = client.Close(); err != nil {
+		t.Errorf("close error: %s", err)
+	}
+
+	waitConns(t, "2037", "2038", "2039")
+
+	// A failed hop closes the hops before it.
+	_, err = goph.New("melbahja", "127.0.10.10",
+		goph.WithPassword("123456"),
+		goph.WithPort(2039),
+		goph.WithInsecureIgnoreHostKey(),
+		goph.WithJumpHosts("melbahja@127.0.10.10:2037,melbahja@127.0.10.10:2038,melbahja@127.0.10.10:2040"),
+	)
+
+	if err == nil || !strings.Contains(err.Error(), "jump melbahja@127.0.10.10:2040") {
+		t.Errorf("expected a jump error of the last hop, got %v", err)
+	}
+
+	waitConns(t, "2037", "2038")
+}
+
+// waitConns waits for the test servers at ports to have no open connections.
+func waitConns(t *testing.T, ports ...string) {
+
+	deadline := time.Now().Add(2 * time.Second)
+	for _, port := range ports {
+		for openConns(port) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("the connections to %s are still open", port)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// newStalledServer accepts a single connection, completes the handshake and
+// then never services global requests, like a peer behind a dead NAT mapping.
+func newStalledServer(port string) {
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+
+	private, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		log.Fatal("Failed to parse private key: ", err)
+	}
+
+	config.AddHostKey(private)
+
+	listener, err := net.Listen("tcp", "127.0.10.10:"+port)
+	if err != nil {
+		log.Fatal("failed to listen for connection: ", err)
+	}
+
+	go func() {
+		nConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		ssh.NewServerConn(nConn, config)
+	}()
+}
+
 func newServer(port string) {
 
 	config := &ssh.ServerConfig{
 		// Remove to disable password auth.
 		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
 			// a production setting.
-			if c.User() == "melbahja" && string(pass) == "123456" {
+			if (c.User() == "melbahja" || c.User() == "nosession") && string(pass) == "123456" {
 				return nil, nil
 			}
 			return nil, fmt.Errorf("password rejected for %q", c.User())
@@ -142,43 +1249,106 @@ func newServer(port string) {
 	go func() {
 		close(ready)
 
-		nConn, err := listener.Accept()
-		if err != nil {
-			return
-		}
+		for {
+			nConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
 
-		// Before use, a handshake must be performed on the incoming
-		// net.Conn.
-		_, chans, reqs, err := ssh.NewServerConn(nConn, config)
-		if err != nil {
-			return
+			go serveConn(nConn, config)
 		}
+	}()
+	<-ready
+	time.Sleep(10 * time.Millisecond)
+}
 
-		// The incoming Request channel must be serviced.
-		go ssh.DiscardRequests(reqs)
+// serverConns counts the open connections of each test server address, and
+// tunnels records the "server -> target" direct-tcpip channels they opened.
+var (
+	serverConns sync.Map
+	tunnels     sync.Map
+)
 
-		// Service the incoming Channel channel.
-		for newChannel := range chans {
-			if newChannel.ChannelType() != "session" {
-				newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
-				continue
-			}
+// openConns returns the number of open connections of the test server at port.
+func openConns(port string) int32 {
 
-			channel, requests, err := newChannel.Accept()
-			if err != nil {
-				log.Fatalf("Could not accept channel: %v", err)
+	n, _ := serverConns.LoadOrStore("127.0.10.10:"+port, new(atomic.Int32))
+	return n.(*atomic.Int32).Load()
+}
+
+func serveConn(nConn net.Conn, config *ssh.ServerConfig) {
+
+	// Before use, a handshake must be performed on the incoming
+	// net.Conn.
+	sConn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		return
+	}
+
+	n, _ := serverConns.LoadOrStore(nConn.LocalAddr().String(), new(atomic.Int32))
+	n.(*atomic.Int32).Add(1)
+	defer n.(*atomic.Int32).Add(-1)
+
+	// The incoming Request channel must be serviced, the test drop
+	// request closes the connection from the server side.
+	go func() {
+		for req := range reqs {
+			if req.Type == "goph-test-drop" {
+				sConn.Close()
 			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
 
-			go func(in <-chan *ssh.Request) {
-				for req := range in {
-					switch req.Type {
-					case "exec":
-						// just return error 0 without exec.
-						channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-					}
-					req.Reply(req.Type == "exec", nil)
-				}
-			}(requests)
+	group := make(chan struct{})
+	killGroup := sync.OnceFunc(func() { close(group) })
+
+	// Service the incoming Channel channel.
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go serveTunnel(nConn.LocalAddr().String(), newChannel)
+			continue
+		}
+
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		// The "nosession" user is refused sessions, like a server at its
+		// MaxSessions limit.
+		if sConn.User() == "nosession" {
+			newChannel.Reject(ssh.Prohibited, "sessions prohibited")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Fatalf("Could not accept channel: %v", err)
+		}
+
+		// "hang" keeps the channel open until a KILL signal, received
+		// signal names are written to stderr. A "hang" wrapped by the
+		// process group prelude ignores signals, like Dropbear, and is
+		// stopped by a "kill -s KILL" command in another session.
+		var hanging atomic.Bool
+		hang := make(chan struct{})
+		release := sync.OnceFunc(func() { close(hang) })
+		killed := func() {
+			channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}{Signal: "KILL"}))
+			release()
+		}
+
+		// Commands read stdin lines from a terminal, the sftp subsystem
+		// serves the local file system.
+		serveTerm := sync.OnceFunc(func() {
 
 			term := terminal.NewTerminal(channel, "> ")
 
@@ -189,11 +1359,156 @@ func newServer(port string) {
 					if err != nil {
 						break
 					}
+					// A shell exits with "exit N".
+					var code uint32
+					if n, _ := fmt.Sscanf(line, "exit %d", &code); n == 1 {
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Code uint32 }{code}))
+						return
+					}
 					fmt.Println(line)
 				}
+				if hanging.Load() {
+					<-hang
+				}
 			}()
-		}
+		})
+
+		go func(in <-chan *ssh.Request) {
+			defer release()
+			wrapped := false
+			for req := range in {
+				switch req.Type {
+				case "subsystem":
+					if string(req.Payload[4:]) == "sftp" {
+						go func() {
+							defer channel.Close()
+							if server, err := sftp.NewServer(channel); err == nil {
+								server.Serve()
+							}
+						}()
+					}
+				case "shell":
+					serveTerm()
+				case "exec":
+					cmd := string(req.Payload[4:])
+					if strings.HasPrefix(cmd, "sudo -S ") {
+						go serveSudo(channel, cmd)
+						break
+					}
+					serveTerm()
+					switch {
+					case cmd == "hang":
+						hanging.Store(true)
+					case strings.HasSuffix(cmd, "; hang"):
+						hanging.Store(true)
+						wrapped = true
+						go func() {
+							select {
+							case <-group:
+								killed()
+							case <-hang:
+							}
+						}()
+					case cmd == "segv":
+						channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+							Signal     string
+							CoreDumped bool
+							Error      string
+							Lang       string
+						}{Signal: "SEGV", CoreDumped: true}))
+					default:
+						if strings.Contains(cmd, "kill -s KILL") {
+							killGroup()
+						}
+						// just return error 0 without exec, "exit N" returns N.
+						var code uint32
+						fmt.Sscanf(cmd, "exit %d", &code)
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Code uint32 }{code}))
+					}
+				case "signal":
+					var sig struct{ Name string }
+					ssh.Unmarshal(req.Payload, &sig)
+					channel.Stderr().Write([]byte(sig.Name + "\n"))
+					if sig.Name == "KILL" && hanging.Load() && !wrapped {
+						killed()
+					}
+				}
+				req.Reply(req.Type == "exec" || req.Type == "shell" || req.Type == "pty-req" || req.Type == "subsystem", nil)
+			}
+		}(requests)
+
+	}
+}
+
+// sudoPassword is the password accepted by the test server sudo.
+var sudoPassword atomic.Value
+
+var (
+	sudoPrompt = regexp.MustCompile(`\[goph-sudo-[0-9a-f]+\] `)
+	sudoReady  = regexp.MustCompile(`goph-sudo-ready-[0-9a-f]+`)
+)
+
+// serveSudo runs a "sudo -S" command like sudo, it prompts for sudoPassword
+// on stderr and prints "root" once the password is read from stdin.
+func serveSudo(channel ssh.Channel, cmd string) {
+
+	defer channel.Close()
+
+	exit := func(code uint32) {
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Code uint32 }{code}))
+	}
+
+	channel.Stderr().Write([]byte(sudoPrompt.FindString(cmd)))
+
+	line, err := bufio.NewReader(channel).ReadString('\n')
+	if err != nil || strings.TrimSuffix(line, "\n") != sudoPassword.Load() {
+		channel.Stderr().Write([]byte("sudo: 1 incorrect password attempt\n"))
+		exit(1)
+		return
+	}
+
+	channel.Stderr().Write([]byte(sudoReady.FindString(cmd) + "\n"))
+	channel.Write([]byte("root\n"))
+	exit(0)
+}
+
+// serveTunnel serves a direct-tcpip channel of the test server at addr, used
+// by jump hosts.
+func serveTunnel(addr string, newChannel ssh.NewChannel) {
+
+	var req struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &req); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	target := net.JoinHostPort(req.Host, fmt.Sprint(req.Port))
+	conn, err := net.Dial("tcp", target)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	tunnels.Store(addr+" -> "+target, true)
+
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
 	}()
-	<-ready
-	time.Sleep(10 * time.Millisecond)
+
+	io.Copy(channel, conn)
 }
@@ -0,0 +1,168 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeepAliveMaxMissed is the number of unanswered keepalive probes
+// after which the client is closed, same as the OpenSSH ServerAliveCountMax default.
+const DefaultKeepAliveMaxMissed = 3
+
+var (
+	// ErrClosed is the close reason of a client closed with Close.
+	ErrClosed = errors.New("goph: client closed")
+
+	// ErrKeepAliveTimeout is the close reason of a client closed after
+	// too many unanswered keepalive probes.
+	ErrKeepAliveTimeout = errors.New("goph: keepalive timeout")
+)
+
+// Done returns a channel that is closed when the SSH connection is closed,
+// either by Close, by the server, by a network error or by the keepalive.
+func (c *Client) Done() <-chan struct{} {
+	return c.doneChan()
+}
+
+// doneChan returns the done channel, creating it on first use.
+func (c *Client) doneChan() chan struct{} {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+
+	return c.done
+}
+
+// Wait blocks until the SSH connection is closed and returns the close reason, see Err.
+func (c *Client) Wait() error {
+	<-c.Done()
+	return c.Err()
+}
+
+// Err returns nil while the connection is open. After Done is closed, it
+// returns ErrClosed if the client was closed with Close, ErrKeepAliveTimeout
+// if the keepalive gave up, or the transport error that closed the connection.
+func (c *Client) Err() error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// setErr records the close reason, the first reason wins.
+func (c *Client) setErr(err error) {
+
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+}
+
+// finish records the close reason and closes Done, it is safe to call more than once.
+func (c *Client) finish(err error) {
+
+	done := c.doneChan()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+	}
+
+	select {
+	case <-done:
+	default:
+		close(done)
+	}
+}
+
+// watch monitors the established connection, starts the keepalive if
+// configured, and when the connection is closed it either reconnects
+// (see WithReconnect) or closes Done.
+func (c *Client) watch(client *ssh.Client) {
+
+	var (
+		closed   = make(chan struct{})
+		timedOut atomic.Bool
+	)
+
+	if c.KeepAliveInterval > 0 {
+		go c.keepAlive(client, closed, &timedOut)
+	}
+
+	go func() {
+
+		err := client.Wait()
+		close(closed)
+
+		switch {
+		case timedOut.Load():
+			err = ErrKeepAliveTimeout
+		case err == nil:
+			err = ErrClosed
+		}
+
+		// The connection is gone, the jump chain it was built on is useless.
+		c.closeJumps()
+
+		if c.reconnect(err) {
+			return
+		}
+
+		c.finish(err)
+	}()
+}
+
+// keepAlive sends a keepalive@openssh.com request every KeepAliveInterval and
+// closes the client when KeepAliveMaxMissed probes in a row were not answered.
+// Any reply, including a failure reply, means the server is alive.
+func (c *Client) keepAlive(client *ssh.Client, closed <-chan struct{}, timedOut *atomic.Bool) {
+
+	maxMissed := c.KeepAliveMaxMissed
+	if maxMissed <= 0 {
+		maxMissed = DefaultKeepAliveMaxMissed
+	}
+
+	ticker := time.NewTicker(c.KeepAliveInterval)
+	defer ticker.Stop()
+
+	var missed atomic.Int32
+	for {
+
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		if int(missed.Add(1)) > maxMissed {
+			timedOut.Store(true)
+			client.Close()
+			return
+		}
+
+		go func() {
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+				missed.Store(0)
+			}
+		}()
+	}
+}
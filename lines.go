@@ -0,0 +1,142 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// lineMaxSize is the size after which a line without terminator is emitted.
+const lineMaxSize = 64 << 10
+
+// Stream identifies the output stream of a remote command.
+type Stream int
+
+const (
+	// StreamStdout is the standard output of a command.
+	StreamStdout Stream = iota + 1
+
+	// StreamStderr is the standard error of a command.
+	StreamStderr
+)
+
+// String returns "stdout" or "stderr".
+func (s Stream) String() string {
+
+	switch s {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	}
+
+	return "unknown"
+}
+
+// Line is a line of output of a remote command, see WithLines.
+type Line struct {
+
+	// Stream is the stream the line was written to.
+	Stream Stream
+
+	// Text is the line without its terminator.
+	Text string
+
+	// Time is the time the end of the line was received.
+	Time time.Time
+}
+
+// setupLines tees the command stdout and stderr to line writers when line
+// callbacks are set, the writers flush their last partial line on Wait.
+func (c *Cmd) setupLines() {
+
+	if len(c.stdoutLines) > 0 {
+		lw := &lineWriter{stream: StreamStdout, mu: &c.linesMu, fns: c.stdoutLines}
+		c.Stdout = teeWriter(c.Stdout, lw)
+		c.closeAfterWait = append(c.closeAfterWait, lw)
+	}
+
+	if len(c.stderrLines) > 0 {
+		lw := &lineWriter{stream: StreamStderr, mu: &c.linesMu, fns: c.stderrLines}
+		c.Stderr = teeWriter(c.Stderr, lw)
+		c.closeAfterWait = append(c.closeAfterWait, lw)
+	}
+}
+
+// teeWriter returns w followed by lw, or lw if w is nil.
+func teeWriter(w io.Writer, lw io.Writer) io.Writer {
+
+	if w == nil {
+		return lw
+	}
+
+	return io.MultiWriter(w, lw)
+}
+
+// lineWriter is an io.Writer that splits its input in lines and calls fns
+// for each one. "\n", "\r\n" and a lone "\r", as written by progress bars,
+// end a line. Line writers of the same command share mu, so callbacks are
+// called one at a time in the order the output is received.
+type lineWriter struct {
+	stream Stream
+	mu     *sync.Mutex
+	fns    []func(Line)
+	buf    []byte
+	cr     bool
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, b := range p {
+
+		switch {
+		case b == '\n' && w.cr:
+			w.cr = false
+		case b == '\n' || b == '\r':
+			w.cr = b == '\r'
+			w.emit()
+		default:
+			w.cr = false
+			w.buf = append(w.buf, b)
+			if len(w.buf) >= lineMaxSize {
+				w.emit()
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close flushes the last partial line.
+func (w *lineWriter) Close() error {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit()
+	}
+
+	return nil
+}
+
+// emit calls the callbacks with the buffered line, w.mu must be held.
+func (w *lineWriter) emit() {
+
+	line := Line{
+		Stream: w.stream,
+		Text:   string(w.buf),
+		Time:   time.Now(),
+	}
+	w.buf = w.buf[:0]
+
+	for _, fn := range w.fns {
+		fn(line)
+	}
+}
@@ -0,0 +1,44 @@
+package goph
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+
+	var (
+		mu    sync.Mutex
+		lines []Line
+	)
+
+	stdout := &lineWriter{stream: StreamStdout, mu: &mu, fns: []func(Line){func(l Line) { lines = append(lines, l) }}}
+	stderr := &lineWriter{stream: StreamStderr, mu: &mu, fns: stdout.fns}
+
+	stdout.Write([]byte("one\r\ntw"))
+	stderr.Write([]byte("oops\n"))
+	stdout.Write([]byte("o\n10%\r"))
+	stdout.Write([]byte("\n50%\r100%\rdone"))
+	stdout.Close()
+	stderr.Close()
+
+	var got []string
+	for _, l := range lines {
+		got = append(got, l.Stream.String()+":"+l.Text)
+	}
+
+	want := []string{
+		"stdout:one",
+		"stderr:oops",
+		"stdout:two",
+		"stdout:10%",
+		"stdout:50%",
+		"stdout:100%",
+		"stdout:done",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// TruncatedError is returned by Cmd.Output, Cmd.CombinedOutput and Client.Run
+// when OutputLimit dropped bytes of the returned output, which holds only the
+// kept head and tail bytes.
+type TruncatedError struct {
+
+	// Offset is the position in the output where the bytes were dropped.
+	Offset int
+
+	// Dropped is the number of dropped bytes.
+	Dropped int
+
+	// Err is the error of the command run, nil if it succeeded.
+	Err error
+}
+
+// Error returns a description of the truncation and the command error.
+func (e *TruncatedError) Error() string {
+
+	if e.Err != nil {
+		return fmt.Sprintf("%v (output truncated, %d bytes dropped)", e.Err, e.Dropped)
+	}
+
+	return fmt.Sprintf("goph: output truncated, %d bytes dropped", e.Dropped)
+}
+
+// Unwrap returns the error of the command run.
+func (e *TruncatedError) Unwrap() error {
+	return e.Err
+}
+
+// OutputLimit bounds the output captured in memory by Cmd.Output,
+// Cmd.CombinedOutput and Client.Run. The zero value means no limit.
+type OutputLimit struct {
+
+	// Head is the number of leading bytes kept.
+	Head int
+
+	// Tail is the number of trailing bytes kept.
+	Tail int
+
+	// Abort interrupts the command, like a done context, once the output
+	// exceeds Head+Tail bytes, the run then returns ErrOutputLimit.
+	Abort bool
+}
+
+// enabled reports whether the limit is set.
+func (l OutputLimit) enabled() bool {
+	return l.Head > 0 || l.Tail > 0
+}
+
+// newOutput returns the buffer capturing the output of Output and CombinedOutput.
+func (c *Cmd) newOutput() *limitBuffer {
+
+	b := &limitBuffer{limit: c.OutputLimit}
+
+	if c.OutputLimit.Abort {
+		b.exceeded = func() {
+			c.abortOnce.Do(func() {
+				close(c.abort)
+			})
+		}
+	}
+
+	return b
+}
+
+// limitBuffer is an io.Writer safe for concurrent use that keeps the whole
+// output, or its head and tail if the limit is set.
+type limitBuffer struct {
+	mu       sync.Mutex
+	limit    OutputLimit
+	head     bytes.Buffer
+	tail     tailBuffer
+	total    int
+	exceeded func()
+}
+
+func (b *limitBuffer) Write(p []byte) (int, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	b.total += n
+
+	if !b.limit.enabled() {
+		return b.head.Write(p)
+	}
+
+	if room := b.limit.Head - b.head.Len(); room > 0 {
+		k := min(room, len(p))
+		b.head.Write(p[:k])
+		p = p[k:]
+	}
+
+	b.tail.max = b.limit.Tail
+	b.tail.Write(p)
+
+	if b.total > b.limit.Head+b.limit.Tail && b.exceeded != nil {
+		b.exceeded()
+	}
+
+	return n, nil
+}
+
+// Truncated reports whether bytes were dropped.
+func (b *limitBuffer) Truncated() bool {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.dropped() > 0
+}
+
+// Bytes returns the kept output.
+func (b *limitBuffer) Bytes() []byte {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.limit.enabled() {
+		return b.head.Bytes()
+	}
+
+	return append(append([]byte(nil), b.head.Bytes()...), b.tail.Bytes()...)
+}
+
+// result returns the kept output and err, as a *TruncatedError if bytes were dropped.
+func (b *limitBuffer) result(err error) ([]byte, error) {
+
+	b.mu.Lock()
+	dropped, offset := b.dropped(), b.head.Len()
+	b.mu.Unlock()
+
+	if dropped > 0 {
+		err = &TruncatedError{Offset: offset, Dropped: dropped, Err: err}
+	}
+
+	return b.Bytes(), err
+}
+
+// dropped returns the number of dropped bytes, b.mu must be held.
+func (b *limitBuffer) dropped() int {
+	return b.total - b.head.Len() - len(b.tail.Bytes())
+}
@@ -0,0 +1,50 @@
+package goph
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLimitBuffer(t *testing.T) {
+
+	tests := []struct {
+		limit     OutputLimit
+		want      string
+		truncated bool
+	}{
+		{OutputLimit{}, "0123456789", false},
+		{OutputLimit{Head: 4, Tail: 6}, "0123456789", false},
+		{OutputLimit{Head: 3, Tail: 2}, "01289", true},
+		{OutputLimit{Head: 4}, "0123", true},
+		{OutputLimit{Tail: 3}, "789", true},
+	}
+
+	for _, tt := range tests {
+
+		exceeded := false
+		b := &limitBuffer{limit: tt.limit, exceeded: func() { exceeded = true }}
+
+		b.Write([]byte("0123"))
+		b.Write([]byte("45678"))
+		b.Write([]byte("9"))
+
+		if got := string(b.Bytes()); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.limit, got, tt.want)
+		}
+
+		if b.Truncated() != tt.truncated || exceeded != tt.truncated {
+			t.Errorf("%+v: truncated %v, exceeded %v, want %v", tt.limit, b.Truncated(), exceeded, tt.truncated)
+		}
+
+		out, err := b.result(ErrCommandFailed)
+
+		var truncErr *TruncatedError
+		if string(out) != tt.want || errors.As(err, &truncErr) != tt.truncated || !errors.Is(err, ErrCommandFailed) {
+			t.Errorf("%+v: unexpected result %q, %v", tt.limit, out, err)
+		}
+
+		if truncErr != nil && (truncErr.Offset != tt.limit.Head || truncErr.Dropped != 10-len(tt.want)) {
+			t.Errorf("%+v: unexpected truncation %+v", tt.limit, truncErr)
+		}
+	}
+}
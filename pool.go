@@ -0,0 +1,407 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultPoolMaxSessions is the default number of sessions per pooled
+	// connection, same as the OpenSSH server MaxSessions default.
+	DefaultPoolMaxSessions = 10
+
+	// DefaultPoolIdleTimeout is the default time an unused pooled connection is kept.
+	DefaultPoolIdleTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrPoolClosed is returned by Pool methods after Close.
+	ErrPoolClosed = errors.New("goph: pool closed")
+
+	// ErrPoolKey is returned by Pool methods for options the pool cannot
+	// identify without WithPoolKey.
+	ErrPoolKey = errors.New("goph: pool key required")
+)
+
+// PoolOption configures a Pool.
+type PoolOption func(*Pool)
+
+// Pool caches connections per user, address, port, transport, auth identity
+// and host key policy, and hands out shared clients for session level work.
+// Options whose identity cannot be compared need WithPoolKey.
+//
+//	p := goph.NewPool(goph.NewDialer(goph.WithKeyFile("id_rsa", "")))
+//	defer p.Close()
+//
+//	out, err := p.Run(ctx, "root", "host1", "uptime")
+type Pool struct {
+	dialer      *Dialer
+	maxSessions int
+	maxConns    int
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	conns   map[string][]*poolConn
+	dialing map[string]int
+	wake    chan struct{}
+	stop    chan struct{}
+	closed  bool
+}
+
+// poolConn is a pooled connection and its session leases.
+type poolConn struct {
+	client   *Client
+	sessions int
+	limit    int
+	lastUsed time.Time
+}
+
+// WithPoolMaxSessions sets the maximum number of concurrent sessions per
+// connection, it must not exceed the server MaxSessions (default DefaultPoolMaxSessions).
+func WithPoolMaxSessions(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxSessions = n
+	}
+}
+
+// WithPoolMaxConns sets the maximum number of connections per key, zero means unlimited.
+// When all connections are busy, callers wait for a session to be released.
+func WithPoolMaxConns(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxConns = n
+	}
+}
+
+// WithPoolIdleTimeout sets how long an unused connection is kept (default DefaultPoolIdleTimeout).
+func WithPoolIdleTimeout(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.idleTimeout = d
+	}
+}
+
+// NewPool creates a Pool dialing with d, a nil d means no default options.
+func NewPool(d *Dialer, opts ...PoolOption) *Pool {
+
+	if d == nil {
+		d = NewDialer()
+	}
+
+	p := &Pool{
+		dialer:      d,
+		maxSessions: DefaultPoolMaxSessions,
+		idleTimeout: DefaultPoolIdleTimeout,
+		conns:       make(map[string][]*poolConn),
+		dialing:     make(map[string]int),
+		wake:        make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.maxSessions <= 0 {
+		p.maxSessions = DefaultPoolMaxSessions
+	}
+
+	go p.evictLoop()
+
+	return p
+}
+
+// Get returns a shared client for user and addr with a free session slot, dialing
+// a new connection if needed, and a release func that must be called once the
+// session level work is done. The client is owned by the pool, do not close it.
+func (p *Pool) Get(ctx context.Context, user, addr string, opts ...Option) (*Client, func(), error) {
+
+	opts = append(append([]Option(nil), p.dialer.opts...), opts...)
+
+	key, err := poolKey(user, addr, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+
+		p.mu.Lock()
+
+		if p.closed {
+			p.mu.Unlock()
+			return nil, nil, ErrPoolClosed
+		}
+
+		if pc := p.lease(key); pc != nil {
+			p.mu.Unlock()
+			return pc.client, p.releaser(pc), nil
+		}
+
+		if p.maxConns <= 0 || len(p.conns[key])+p.dialing[key] < p.maxConns {
+			p.dialing[key]++
+			p.mu.Unlock()
+			return p.dial(ctx, key, user, addr, opts)
+		}
+
+		wake := p.wake
+		p.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// Run runs cmd on a pooled connection and returns its combined output, like Client.RunContext.
+// A session rejected by the server lowers the connection session limit and is retried once.
+func (p *Pool) Run(ctx context.Context, user, addr, cmd string, opts ...Option) (out []byte, err error) {
+
+	for retry := 0; retry < 2; retry++ {
+
+		var (
+			client  *Client
+			release func()
+		)
+
+		if client, release, err = p.Get(ctx, user, addr, opts...); err != nil {
+			return nil, err
+		}
+
+		out, err = client.RunContext(ctx, cmd)
+
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) && openErr.Reason == ssh.Prohibited {
+			p.lowerLimit(client)
+			release()
+			continue
+		}
+
+		release()
+		return out, err
+	}
+
+	return out, err
+}
+
+// Close closes all pooled connections, clients in use are closed too.
+func (p *Pool) Close() (err error) {
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.closed = true
+	conns := p.conns
+	p.conns = make(map[string][]*poolConn)
+	close(p.stop)
+	close(p.wake)
+	p.mu.Unlock()
+
+	for _, list := range conns {
+		for _, pc := range list {
+			if cerr := pc.client.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+
+	return err
+}
+
+// lease takes a session slot on a live connection of key, p.mu must be held.
+func (p *Pool) lease(key string) *poolConn {
+
+	for _, pc := range p.conns[key] {
+		if pc.sessions < pc.limit && pc.client.Err() == nil {
+			pc.sessions++
+			pc.lastUsed = time.Now()
+			return pc
+		}
+	}
+
+	return nil
+}
+
+// dial connects a new client for key and leases its first session slot.
+func (p *Pool) dial(ctx context.Context, key, user, addr string, opts []Option) (*Client, func(), error) {
+
+	client, err := NewContext(ctx, user, addr, opts...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dialing[key]--
+	p.broadcast()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.closed {
+		client.Close()
+		return nil, nil, ErrPoolClosed
+	}
+
+	pc := &poolConn{
+		client:   client,
+		sessions: 1,
+		limit:    p.maxSessions,
+		lastUsed: time.Now(),
+	}
+	p.conns[key] = append(p.conns[key], pc)
+
+	return client, p.releaser(pc), nil
+}
+
+// releaser returns a func that releases a session slot of pc once.
+func (p *Pool) releaser(pc *poolConn) func() {
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			pc.sessions--
+			pc.lastUsed = time.Now()
+			p.broadcast()
+			p.mu.Unlock()
+		})
+	}
+}
+
+// lowerLimit lowers the session limit of the connection of client after the
+// server refused a session, so the pool respects the server MaxSessions.
+func (p *Pool) lowerLimit(client *Client) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, list := range p.conns {
+		for _, pc := range list {
+			if pc.client == client && pc.sessions > 1 {
+				pc.limit = pc.sessions - 1
+			}
+		}
+	}
+}
+
+// broadcast wakes the callers waiting for a session slot, p.mu must be held.
+func (p *Pool) broadcast() {
+	if !p.closed {
+		close(p.wake)
+		p.wake = make(chan struct{})
+	}
+}
+
+// evictLoop periodically closes idle and dead connections.
+func (p *Pool) evictLoop() {
+
+	interval := p.idleTimeout / 2
+	if interval <= 0 || interval > time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.evict()
+		}
+	}
+}
+
+// evict removes dead connections and closes the idle ones.
+func (p *Pool) evict() {
+
+	var idle []*Client
+
+	p.mu.Lock()
+	for key, list := range p.conns {
+
+		alive := list[:0]
+		for _, pc := range list {
+			switch {
+			case pc.client.Err() != nil && pc.sessions == 0:
+			case pc.sessions == 0 && time.Since(pc.lastUsed) > p.idleTimeout:
+				idle = append(idle, pc.client)
+			default:
+				alive = append(alive, pc)
+			}
+		}
+
+		if len(alive) == 0 {
+			delete(p.conns, key)
+		} else {
+			p.conns[key] = alive
+		}
+	}
+	p.mu.Unlock()
+
+	for _, client := range idle {
+		client.Close()
+	}
+}
+
+// poolKey applies opts to a scratch client, like New does, and returns the key
+// identifying the user, address, port, transport, auth identity and host key policy.
+func poolKey(user, addr string, opts []Option) (string, error) {
+
+	c := &Client{
+		User: user,
+		Addr: addr,
+		Port: 22,
+	}
+
+	config := &ssh.ClientConfig{User: user}
+
+	for _, opt := range opts {
+		if err := opt(c, config); err != nil {
+			return "", err
+		}
+	}
+
+	if len(c.unkeyed) > 0 && c.poolKey == "" {
+		return "", fmt.Errorf("%w: %s cannot be compared, use WithPoolKey", ErrPoolKey, strings.Join(c.unkeyed, ", "))
+	}
+
+	var jumps []string
+	for _, jh := range c.JumpHosts {
+		jumps = append(jumps, jh.Spec)
+	}
+
+	return strings.Join([]string{
+		c.User,
+		c.Addr,
+		fmt.Sprint(c.Port),
+		c.ProxyURL,
+		c.ProxyCommand,
+		fmt.Sprintf("%p", c.Jump),
+		strings.Join(jumps, ","),
+		c.proxyJump,
+		strings.Join(c.authIDs, ","),
+		c.hostKeyID,
+		c.poolKey,
+	}, "\x00"), nil
+}
+
+// hashID returns a short non reversible identifier of a secret.
+func hashID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
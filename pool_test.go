@@ -0,0 +1,57 @@
+package goph
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPoolKey(t *testing.T) {
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(knownHosts, nil, 0600)
+
+	key := func(opts ...Option) string {
+		k, err := poolKey("root", "host", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	tests := []struct {
+		name string
+		a, b []Option
+	}{
+		{"password", []Option{WithPassword("a")}, []Option{WithPassword("b")}},
+		{"host key policy", []Option{WithInsecureIgnoreHostKey()}, []Option{WithKnownHosts(knownHosts)}},
+		{"default host key policy", nil, []Option{WithInsecureIgnoreHostKey()}},
+		{"pool key", []Option{WithAuth(ssh.Password("a")), WithPoolKey("a")}, []Option{WithAuth(ssh.Password("b")), WithPoolKey("b")}},
+	}
+
+	for _, tt := range tests {
+		if key(tt.a...) == key(tt.b...) {
+			t.Errorf("%s: expected different keys", tt.name)
+		}
+	}
+
+	if key(WithPassword("a")) != key(WithPassword("a")) {
+		t.Error("expected the same key for the same options")
+	}
+
+	unkeyed := []Option{
+		WithAuth(ssh.Password("a")),
+		WithKeyboardInteractive(nil),
+		WithHostKeyCallback(ssh.InsecureIgnoreHostKey()),
+		WithConfig(func(*ssh.ClientConfig) error { return nil }),
+	}
+
+	for _, opt := range unkeyed {
+		if _, err := poolKey("root", "host", []Option{opt}); !errors.Is(err, ErrPoolKey) {
+			t.Errorf("expected ErrPoolKey, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialProxy returns a TCP connection to addr through the proxy described by proxyURL.
+// Supported schemes are socks5, socks5h, http and https, a host:port without
+// scheme is a SOCKS5 proxy. With localDNS, socks5 resolves addr locally.
+func dialProxy(ctx context.Context, proxyURL string, tlsConfig *tls.Config, localDNS bool, addr string) (net.Conn, error) {
+
+	if !strings.Contains(proxyURL, "://") {
+		proxyURL = "socks5://" + proxyURL
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: invalid proxy URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return dialSOCKS5(ctx, u, localDNS && u.Scheme == "socks5", addr)
+	case "http", "https":
+		return dialHTTPConnect(ctx, u, tlsConfig, addr)
+	default:
+		return nil, fmt.Errorf("proxy: unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// dialSOCKS5 returns a TCP connection to addr through a SOCKS5 proxy.
+// The hostname is sent to the proxy and resolved remotely, unless localDNS.
+func dialSOCKS5(ctx context.Context, u *url.URL, localDNS bool, addr string) (net.Conn, error) {
+
+	var auth *proxy.Auth
+	if u.User != nil {
+		auth = &proxy.Auth{
+			User: u.User.Username(),
+		}
+		if pass, ok := u.User.Password(); ok {
+			auth.Password = pass
+		}
+	}
+
+	if localDNS {
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy dial: %w", err)
+		}
+
+		if net.ParseIP(host) == nil {
+			ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+			if err != nil {
+				return nil, fmt.Errorf("proxy dial: %w", err)
+			}
+			addr = net.JoinHostPort(ips[0].String(), port)
+		}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("proxy dial: %w", err)
+	}
+
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy dial: %w", err)
+	}
+
+	return conn, nil
+}
+
+// dialHTTPConnect returns a TCP connection to addr through an HTTP CONNECT proxy.
+// Credentials in the URL are sent with basic auth, and with the https scheme
+// the proxy connection is wrapped in TLS using tlsConfig (nil means defaults).
+func dialHTTPConnect(ctx context.Context, u *url.URL, tlsConfig *tls.Config, addr string) (net.Conn, error) {
+
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy dial: %w", err)
+	}
+
+	if u.Scheme == "https" {
+
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy tls: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass)))
+	}
+
+	// Unblock the request when ctx is done.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	br := bufio.NewReader(conn)
+	if err = req.Write(conn); err == nil {
+		var resp *http.Response
+		if resp, err = http.ReadResponse(br, req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("proxy responded %s", resp.Status)
+			}
+		}
+	}
+
+	if !stop() {
+		conn.Close()
+		return nil, fmt.Errorf("proxy dial: %w", context.Cause(ctx))
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy dial: %w", err)
+	}
+
+	// The SSH server may send its version before we read, keep what was buffered.
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn that reads the buffered bytes first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
@@ -0,0 +1,161 @@
+package goph
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDialHTTPConnectProxy(t *testing.T) {
+
+	// Target that speaks first, like an SSH server sending its version.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("SSH-2.0-Test\r\n"))
+		io.Copy(io.Discard, conn)
+	}()
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodConnect {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if user, pass, ok := parseProxyAuth(r); !ok || user != "user" || pass != "p@ss" {
+			http.Error(w, "auth required", http.StatusProxyAuthRequired)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+		conn.Close()
+		upstream.Close()
+	}))
+	defer proxyServer.Close()
+
+	proxyURL := "http://user:p%40ss@" + proxyServer.Listener.Addr().String()
+
+	conn, err := dialProxy(context.Background(), proxyURL, nil, false, target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line != "SSH-2.0-Test\r\n" {
+		t.Fatalf("unexpected banner %q", line)
+	}
+
+	// Wrong credentials must be rejected.
+	_, err = dialProxy(context.Background(), "http://user:wrong@"+proxyServer.Listener.Addr().String(), nil, false, target.Addr().String())
+	if err == nil {
+		t.Fatal("expected an error with wrong proxy credentials")
+	}
+}
+
+func TestDialProxyUnsupportedScheme(t *testing.T) {
+	if _, err := dialProxy(context.Background(), "ftp://127.0.0.1:21", nil, false, "127.0.0.1:22"); err == nil {
+		t.Fatal("expected an error for unsupported proxy scheme")
+	}
+}
+
+func TestDialSOCKS5Resolve(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// A minimal SOCKS5 server that reports the requested host.
+	hosts := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			buf := make([]byte, 262)
+			io.ReadFull(conn, buf[:2])
+			io.ReadFull(conn, buf[:buf[1]])
+			conn.Write([]byte{5, 0})
+
+			io.ReadFull(conn, buf[:4])
+			switch buf[3] {
+			case 1:
+				io.ReadFull(conn, buf[:4])
+				hosts <- net.IP(buf[:4]).String()
+			case 3:
+				io.ReadFull(conn, buf[:1])
+				n := int(buf[0])
+				io.ReadFull(conn, buf[:n])
+				hosts <- string(buf[:n])
+			}
+
+			io.ReadFull(conn, buf[:2])
+			conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		proxyURL string
+		localDNS bool
+		want     string
+	}{
+		{"socks5://" + ln.Addr().String(), false, "localhost"},
+		{"socks5h://" + ln.Addr().String(), true, "localhost"},
+		{ln.Addr().String(), false, "localhost"},
+		{"socks5://" + ln.Addr().String(), true, "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+
+		conn, err := dialProxy(context.Background(), tt.proxyURL, nil, tt.localDNS, "localhost:22")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.proxyURL, err)
+		}
+		conn.Close()
+
+		if got := <-hosts; got != tt.want {
+			t.Errorf("%s local %v: proxy got host %q, want %q", tt.proxyURL, tt.localDNS, got, tt.want)
+		}
+	}
+}
+
+func parseProxyAuth(r *http.Request) (string, string, bool) {
+	req := &http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+	return req.BasicAuth()
+}
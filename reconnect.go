@@ -0,0 +1,197 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ReconnectPolicy configures how a Client reconnects when its connection dies, see WithReconnect.
+type ReconnectPolicy struct {
+
+	// MaxAttempts is the number of attempts per disconnection, zero means unlimited.
+	MaxAttempts int
+
+	// InitialDelay is the delay before the first attempt, default 1 second.
+	InitialDelay time.Duration
+
+	// MaxDelay caps the delay between attempts, default 1 minute.
+	MaxDelay time.Duration
+
+	// Multiplier grows the delay after each failed attempt, default 2.
+	Multiplier float64
+
+	// OnEvent, if non-nil, is called after each reconnect attempt.
+	OnEvent func(ReconnectEvent)
+}
+
+// ReconnectEvent describes a reconnect attempt.
+type ReconnectEvent struct {
+
+	// Cause is the reason the connection was lost.
+	Cause error
+
+	// Attempt is the attempt number, starting at 1 for each disconnection.
+	Attempt int
+
+	// Err is the attempt error, nil when the client is reconnected.
+	Err error
+}
+
+// backoff returns the delay to wait before the next attempt.
+func (p *ReconnectPolicy) backoff(prev time.Duration) time.Duration {
+
+	if prev == 0 {
+		if p.InitialDelay > 0 {
+			return p.InitialDelay
+		}
+		return time.Second
+	}
+
+	multiplier, maxDelay := p.Multiplier, p.MaxDelay
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+
+	if next := time.Duration(float64(prev) * multiplier); next < maxDelay {
+		return next
+	}
+
+	return maxDelay
+}
+
+// reconnect redials the client after its connection was lost for cause.
+// It reports whether the client is connected again, new sessions wait for it
+// meanwhile. It gives up when the policy attempts are exhausted or the client is closed.
+func (c *Client) reconnect(cause error) bool {
+
+	p := c.Reconnect
+	if p == nil || c.config == nil || c.closed() {
+		return false
+	}
+
+	c.mu.Lock()
+	c.ready = make(chan struct{})
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-c.closingChan():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var delay time.Duration
+	for attempt := 1; p.MaxAttempts <= 0 || attempt <= p.MaxAttempts; attempt++ {
+
+		delay = p.backoff(delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+
+		err := DialContext(ctx, c, c.config)
+
+		if err == nil && c.closed() {
+			// Closed while dialing, drop the new connection.
+			c.current().Close()
+			return false
+		}
+
+		if p.OnEvent != nil {
+			p.OnEvent(ReconnectEvent{Cause: cause, Attempt: attempt, Err: err})
+		}
+
+		if err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setClient sets the established connection and starts watching it.
+func (c *Client) setClient(client *ssh.Client) {
+
+	c.mu.Lock()
+	c.Client = client
+	if c.ready == nil {
+		c.ready = make(chan struct{})
+	}
+	select {
+	case <-c.ready:
+	default:
+		close(c.ready)
+	}
+	c.mu.Unlock()
+
+	c.watch(client)
+}
+
+// current returns the current connection.
+func (c *Client) current() *ssh.Client {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Client
+}
+
+// connected returns the current connection, waiting while the client is reconnecting.
+func (c *Client) connected() (*ssh.Client, error) {
+
+	done := c.doneChan()
+
+	c.mu.Lock()
+	ready := c.ready
+	c.mu.Unlock()
+
+	if ready != nil {
+		select {
+		case <-ready:
+		case <-done:
+			return nil, c.Err()
+		}
+	}
+
+	return c.current(), nil
+}
+
+// closingChan returns the channel closed by Close, creating it on first use.
+func (c *Client) closingChan() chan struct{} {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing == nil {
+		c.closing = make(chan struct{})
+	}
+
+	return c.closing
+}
+
+// closed reports whether Close was called.
+func (c *Client) closed() bool {
+
+	select {
+	case <-c.closingChan():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// DefaultScriptDir is the remote directory of the scripts uploaded by
+// Client.Script, see WithScriptDir.
+const DefaultScriptDir = "/tmp"
+
+// scriptOptions are the Script settings of a Cmd, see WithScriptArgs,
+// WithShebang and WithScriptUpload.
+type scriptOptions struct {
+	args    []string
+	shebang bool
+	upload  bool
+	dir     string
+
+	// file is the uploaded script, removed once by Cmd.removeScript.
+	file   string
+	remove sync.Once
+}
+
+// closerFunc is an io.Closer calling the func.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// shebang reads the "#!" line of the script, it returns the interpreter
+// command line, empty if none, and a reader of the whole script.
+func shebang(r io.Reader) (string, io.Reader, error) {
+
+	br := bufio.NewReader(r)
+
+	if prefix, _ := br.Peek(2); string(prefix) != "#!" {
+		return "", br, nil
+	}
+
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+
+	return strings.TrimSpace(line[2:]), io.MultiReader(strings.NewReader(line), br), nil
+}
+
+// stdinArgs returns the args making the interpreter read the script from
+// stdin and pass args to it, "-s --" for shells and "-" for the others, like
+// python, perl, ruby or node.
+func stdinArgs(interpreter string, args []string) []string {
+
+	var program string
+	for _, field := range strings.Fields(interpreter) {
+		if base := path.Base(field); base != "env" && !strings.HasPrefix(field, "-") {
+			program = base
+			break
+		}
+	}
+
+	switch program {
+	case "sh", "bash", "dash", "zsh", "ksh", "mksh", "ash":
+		return append([]string{"-s", "--"}, args...)
+	}
+
+	return append([]string{"-"}, args...)
+}
+
+// uploadScript writes the script to a new temp file in the remote dir and
+// returns its path.
+func (c *Client) uploadScript(r io.Reader, dir string) (string, error) {
+
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return "", err
+	}
+	defer ftp.Close()
+
+	if dir == "" {
+		dir = DefaultScriptDir
+	}
+
+	name := path.Join(dir, fmt.Sprintf("goph-script-%x", token))
+
+	f, err := ftp.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return "", fmt.Errorf("goph: upload script: %w", err)
+	}
+
+	if err = f.Chmod(0700); err == nil {
+		_, err = io.Copy(f, r)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		ftp.Remove(name)
+		return "", fmt.Errorf("goph: upload script: %w", err)
+	}
+
+	return name, nil
+}
+
+// removeScript removes the script file uploaded by Client.Script, once.
+func (c *Cmd) removeScript() (err error) {
+
+	if c.script == nil || c.script.file == "" {
+		return nil
+	}
+
+	c.script.remove.Do(func() {
+
+		var ftp *sftp.Client
+		if ftp, err = c.client.NewSftp(); err != nil {
+			return
+		}
+		defer ftp.Close()
+
+		err = ftp.Remove(c.script.file)
+	})
+
+	return err
+}
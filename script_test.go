@@ -0,0 +1,52 @@
+package goph
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShebang(t *testing.T) {
+
+	script := "#!/usr/bin/env python3\nprint('hi')\n"
+
+	interpreter, r, err := shebang(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if interpreter != "/usr/bin/env python3" {
+		t.Errorf("unexpected interpreter %q", interpreter)
+	}
+
+	if b, _ := io.ReadAll(r); string(b) != script {
+		t.Errorf("script was not kept whole, got %q", b)
+	}
+
+	interpreter, r, _ = shebang(strings.NewReader("echo hi"))
+	if b, _ := io.ReadAll(r); interpreter != "" || string(b) != "echo hi" {
+		t.Errorf("unexpected interpreter %q and script %q", interpreter, b)
+	}
+}
+
+func TestStdinArgs(t *testing.T) {
+
+	tests := map[string][]string{
+		"/bin/sh":              {"-s", "--", "a b"},
+		"/bin/bash -e":         {"-s", "--", "a b"},
+		"/usr/bin/env -S bash": {"-s", "--", "a b"},
+		"/usr/bin/env python3": {"-", "a b"},
+	}
+
+	for interpreter, want := range tests {
+		if got := stdinArgs(interpreter, []string{"a b"}); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", interpreter, got, want)
+		}
+	}
+
+	c := &Cmd{Path: "/bin/sh", Args: stdinArgs("/bin/sh", []string{"it's", "x"})}
+	if got, want := c.String(), `/bin/sh -s -- 'it'\''s' x`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Shell opens an interactive login shell on the remote host with a pty, wired
+// to the local stdin, stdout and stderr, like running ssh host.
+//
+// If stdin is a terminal, it is put in raw mode and restored when the shell
+// exits, and local terminal size changes are forwarded to the remote pty.
+// The pty term type is taken from the TERM environment variable.
+// Options are applied after the defaults, so WithPty, WithStdout and
+// WithStderr can override them.
+//
+// Shell returns when the remote shell exits, a non zero exit status is
+// returned as *ExitError. When ctx is done, the shell is interrupted and ctx
+// error is returned. The local stdin is not read after Shell returns.
+func (c *Client) Shell(ctx context.Context, opts ...CmdOption) error {
+
+	cmd, err := c.CommandContext(ctx, "")
+	if err != nil {
+		return err
+	}
+	defer cmd.Close()
+
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+
+	fd, cols, rows := int(os.Stdin.Fd()), 80, 24
+	isTerm := term.IsTerminal(fd)
+	if isTerm {
+		if w, h, err := term.GetSize(fd); err == nil {
+			cols, rows = w, h
+		}
+	}
+
+	cmd.Apply(WithPty(termType, cols, rows, ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}))
+	cmd.Apply(opts...)
+
+	// Stdin is copied by Shell instead of the session, which would keep
+	// reading it after the remote shell exited.
+	var (
+		stdin  io.WriteCloser
+		reader *stdinReader
+	)
+
+	if cmd.Stdin == nil {
+
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+
+		if reader, err = newStdinReader(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	if isTerm {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+	}
+
+	if err = cmd.start(cmd.Session.Shell); err != nil {
+		if reader != nil {
+			reader.stop()
+		}
+		return err
+	}
+
+	if isTerm {
+		stop := watchResize(fd, cmd)
+		defer stop()
+	}
+
+	if reader != nil {
+		reader.copyTo(stdin)
+		defer reader.stop()
+	}
+
+	return cmd.Wait()
+}
@@ -0,0 +1,84 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+//go:build !unix
+
+package goph
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// watchResize polls the terminal fd size and forwards changes to cmd,
+// there is no SIGWINCH on this platform. It runs until stop is called.
+func watchResize(fd int, cmd *Cmd) (stop func()) {
+
+	done := make(chan struct{})
+
+	go func() {
+
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+
+		w, h, _ := term.GetSize(fd)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if nw, nh, err := term.GetSize(fd); err == nil && (nw != w || nh != h) {
+					w, h = nw, nh
+					cmd.Resize(w, h)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// stdinReader reads f until stop is called. A pending read cannot be
+// interrupted on this platform, the input it returns after stop is dropped.
+type stdinReader struct {
+	f       *os.File
+	stopped atomic.Bool
+}
+
+func newStdinReader(f *os.File) (*stdinReader, error) {
+	return &stdinReader{f: f}, nil
+}
+
+// copyTo copies the reader to w until it ends or stop is called, then closes w.
+func (r *stdinReader) copyTo(w io.WriteCloser) {
+
+	go func() {
+		io.Copy(w, r)
+		w.Close()
+	}()
+}
+
+// stop makes the next Read return io.EOF.
+func (r *stdinReader) stop() {
+	r.stopped.Store(true)
+}
+
+func (r *stdinReader) Read(p []byte) (int, error) {
+
+	if r.stopped.Load() {
+		return 0, io.EOF
+	}
+
+	n, err := r.f.Read(p)
+	if r.stopped.Load() {
+		return 0, io.EOF
+	}
+
+	return n, err
+}
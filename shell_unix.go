@@ -0,0 +1,113 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+//go:build unix
+
+package goph
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// watchResize forwards the terminal fd size changes to cmd on SIGWINCH,
+// until the returned stop func is called.
+func watchResize(fd int, cmd *Cmd) (stop func()) {
+
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, syscall.SIGWINCH)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sig:
+				if w, h, err := term.GetSize(fd); err == nil {
+					cmd.Resize(w, h)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+// stdinReader reads f only once it is readable, so a stopped reader does not
+// consume the input typed after the shell exited.
+type stdinReader struct {
+	f     *os.File
+	wakeR *os.File
+	wakeW *os.File
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newStdinReader(f *os.File) (*stdinReader, error) {
+
+	wakeR, wakeW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return &stdinReader{f: f, wakeR: wakeR, wakeW: wakeW}, nil
+}
+
+// copyTo copies the reader to w until it ends or stop is called, then closes w.
+func (r *stdinReader) copyTo(w io.WriteCloser) {
+
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+		io.Copy(w, r)
+		w.Close()
+	}()
+}
+
+// stop interrupts a pending Read and waits for copyTo to return.
+func (r *stdinReader) stop() {
+
+	r.once.Do(func() {
+		r.wakeW.Close()
+		if r.done != nil {
+			<-r.done
+		}
+		r.wakeR.Close()
+	})
+}
+
+func (r *stdinReader) Read(p []byte) (int, error) {
+
+	fds := []unix.PollFd{
+		{Fd: int32(r.wakeR.Fd()), Events: unix.POLLIN},
+		{Fd: int32(r.f.Fd()), Events: unix.POLLIN},
+	}
+
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return 0, err
+		}
+
+		if fds[0].Revents != 0 {
+			return 0, io.EOF
+		}
+
+		if fds[1].Revents != 0 {
+			return r.f.Read(p)
+		}
+	}
+}
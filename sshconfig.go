@@ -0,0 +1,553 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// maxSSHConfigDepth limits Include recursion, same as OpenSSH.
+const maxSSHConfigDepth = 16
+
+// sshConfig holds the resolved ssh_config values for a single host.
+// Like OpenSSH, the first obtained value of each keyword wins,
+// except for IdentityFile which accumulates.
+type sshConfig struct {
+	values        map[string]string
+	identityFiles []string
+}
+
+// get returns the resolved value of the lowercase keyword.
+func (sc *sshConfig) get(keyword string) (string, bool) {
+	v, ok := sc.values[keyword]
+	return v, ok
+}
+
+// sshConfigResolver evaluates ssh_config files for a target host.
+type sshConfigResolver struct {
+	host      string
+	user      string
+	localUser string
+	dir       string
+	cfg       *sshConfig
+}
+
+// DefaultSSHConfigPath returns the default user ssh_config file path.
+func DefaultSSHConfigPath() (string, error) {
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ssh", "config"), nil
+}
+
+// parseSSHConfig resolves the ssh_config file at path for host and user.
+func parseSSHConfig(path, host, remoteUser string) (*sshConfig, error) {
+
+	r := &sshConfigResolver{
+		host: host,
+		user: remoteUser,
+		dir:  filepath.Dir(path),
+		cfg:  &sshConfig{values: make(map[string]string)},
+	}
+
+	if u, err := user.Current(); err == nil {
+		r.localUser = u.Username
+	}
+
+	if err := r.readFile(path, true, 0); err != nil {
+		return nil, err
+	}
+
+	return r.cfg, nil
+}
+
+// readFile reads a config file, active reports whether the enclosing block matches.
+func (r *sshConfigResolver) readFile(path string, active bool, depth int) error {
+
+	if depth > maxSSHConfigDepth {
+		return fmt.Errorf("ssh config: include nested too deeply: %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("ssh config: %s line %d: %w", path, n, err)
+		}
+
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			active = matchPatternList(r.host, args)
+		case "match":
+			if active, err = r.match(args); err != nil {
+				return fmt.Errorf("ssh config: %s line %d: %w", path, n, err)
+			}
+		case "include":
+			if !active {
+				continue
+			}
+			for _, arg := range args {
+				if err = r.include(arg, depth); err != nil {
+					return err
+				}
+			}
+		default:
+			if !active || len(args) == 0 {
+				continue
+			}
+			if keyword == "identityfile" {
+				r.cfg.identityFiles = append(r.cfg.identityFiles, args[0])
+				continue
+			}
+			if _, ok := r.cfg.values[keyword]; !ok {
+				r.cfg.values[keyword] = strings.Join(args, " ")
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// include reads the files matching pattern, relative paths are resolved
+// against the directory of the main config file.
+func (r *sshConfigResolver) include(pattern string, depth int) error {
+
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(r.dir, pattern)
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("ssh config: include %s: %w", pattern, err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		// Included files start active, Host and Match lines inside them
+		// only affect the included file itself.
+		if err = r.readFile(file, true, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// match evaluates the criteria of a Match line.
+func (r *sshConfigResolver) match(args []string) (bool, error) {
+
+	if len(args) == 0 {
+		return false, fmt.Errorf("match: missing criteria")
+	}
+
+	result := true
+	for i := 0; i < len(args); i++ {
+
+		criteria := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criteria, "!")
+		criteria = strings.TrimPrefix(criteria, "!")
+
+		var matched bool
+		switch criteria {
+		case "all":
+			matched = true
+		case "final":
+			// goph resolves the config in a single final pass.
+			matched = true
+		case "canonical":
+			matched = false
+		case "host", "originalhost", "user", "localuser", "exec":
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("match: %s requires an argument", criteria)
+			}
+			i++
+			patterns := strings.Split(args[i], ",")
+			switch criteria {
+			case "host":
+				matched = matchPatternList(r.hostname(), patterns)
+			case "originalhost":
+				matched = matchPatternList(r.host, patterns)
+			case "user":
+				matched = matchPatternList(r.remoteUser(), patterns)
+			case "localuser":
+				matched = matchPatternList(r.localUser, patterns)
+			case "exec":
+				// Running local commands is not supported.
+				matched = false
+			}
+		default:
+			return false, fmt.Errorf("match: unsupported criteria %q", criteria)
+		}
+
+		if matched == negate {
+			result = false
+		}
+	}
+
+	return result, nil
+}
+
+// hostname returns the target hostname after HostName substitution so far.
+func (r *sshConfigResolver) hostname() string {
+	if v, ok := r.cfg.values["hostname"]; ok {
+		return strings.ReplaceAll(v, "%h", r.host)
+	}
+	return r.host
+}
+
+// remoteUser returns the target user resolved so far.
+func (r *sshConfigResolver) remoteUser() string {
+	if r.user != "" {
+		return r.user
+	}
+	if v, ok := r.cfg.values["user"]; ok {
+		return v
+	}
+	return r.localUser
+}
+
+// splitSSHConfigLine splits a config line into a lowercase keyword and its arguments.
+func splitSSHConfigLine(line string) (keyword string, args []string, err error) {
+
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return strings.ToLower(line), nil, nil
+	}
+
+	keyword = strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	for rest != "" {
+
+		var arg string
+		if rest[0] == '"' {
+			end = strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			arg, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end = strings.IndexAny(rest, " \t")
+			if end == -1 {
+				end = len(rest)
+			}
+			arg, rest = rest[:end], rest[end:]
+		}
+
+		if strings.HasPrefix(arg, "#") {
+			break
+		}
+
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+
+	return keyword, args, nil
+}
+
+// matchPatternList reports whether s matches the OpenSSH pattern list.
+// Patterns may be separated by commas, and a pattern prefixed with "!"
+// rejects the match even if another pattern matches.
+func matchPatternList(s string, patterns []string) bool {
+
+	s = strings.ToLower(s)
+	matched := false
+
+	for _, list := range patterns {
+		for _, pattern := range strings.Split(list, ",") {
+
+			pattern = strings.ToLower(pattern)
+			if strings.HasPrefix(pattern, "!") {
+				if matchWildcard(s, pattern[1:]) {
+					return false
+				}
+				continue
+			}
+
+			if matchWildcard(s, pattern) {
+				matched = true
+			}
+		}
+	}
+
+	return matched
+}
+
+// matchWildcard matches s against pattern where "*" matches any sequence
+// and "?" matches exactly one character.
+func matchWildcard(s, pattern string) bool {
+
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchWildcard(s[i:], pattern) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		s, pattern = s[1:], pattern[1:]
+	}
+
+	return s == ""
+}
+
+// expandHome replaces a leading "~" with the user home directory.
+func expandHome(path string) string {
+
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
+
+// expandSSHTokens expands the OpenSSH %-tokens and a leading "~" in s.
+// If quote is not nil, the expanded values are quoted with it.
+func expandSSHTokens(s string, c *Client, originalHost string, quote func(string) string) string {
+
+	if quote == nil {
+		quote = func(v string) string {
+			return v
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+
+		if s[i] != '%' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'h':
+			b.WriteString(quote(c.Addr))
+		case 'n':
+			b.WriteString(quote(originalHost))
+		case 'p':
+			b.WriteString(quote(strconv.FormatUint(uint64(c.Port), 10)))
+		case 'r':
+			b.WriteString(quote(c.User))
+		case 'd':
+			home, _ := os.UserHomeDir()
+			b.WriteString(quote(home))
+		case 'u':
+			if u, err := user.Current(); err == nil {
+				b.WriteString(quote(u.Username))
+			}
+		default:
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+
+	return expandHome(b.String())
+}
+
+// applySSHConfig resolves the ssh_config file at path for c.Addr and applies
+// the supported keywords to c and config. Missing files are ignored when
+// mustExist is false.
+func applySSHConfig(c *Client, config *ssh.ClientConfig, path string, mustExist bool) error {
+
+	path = expandHome(path)
+
+	sc, err := parseSSHConfig(path, c.Addr, c.User)
+	if err != nil {
+		if !mustExist && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	c.sshConfig = path
+	originalHost := c.Addr
+
+	if v, ok := sc.get("hostname"); ok {
+		c.Addr = strings.ReplaceAll(v, "%h", originalHost)
+	}
+
+	if v, ok := sc.get("user"); ok && c.User == "" {
+		c.User = v
+		config.User = v
+	}
+
+	if v, ok := sc.get("port"); ok {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return fmt.Errorf("ssh config: invalid port %q", v)
+		}
+		c.Port = uint(port)
+	}
+
+	if v, ok := sc.get("connecttimeout"); ok {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("ssh config: invalid connect timeout %q", v)
+		}
+		config.Timeout = time.Duration(seconds) * time.Second
+	}
+
+	if v, ok := sc.get("hostkeyalgorithms"); ok {
+		config.HostKeyAlgorithms = applyAlgorithmList(ssh.SupportedAlgorithms().HostKeys, v)
+	}
+
+	if v, ok := sc.get("userknownhostsfile"); ok && v != "none" {
+
+		var files []string
+		for _, file := range strings.Fields(v) {
+			file = expandSSHTokens(file, c, originalHost, nil)
+			if _, err := os.Stat(file); err == nil {
+				files = append(files, file)
+			}
+		}
+
+		var cb ssh.HostKeyCallback
+		if len(files) > 0 {
+			cb, err = knownhosts.New(files...)
+		} else {
+			cb, err = EnsureKnownHosts(expandSSHTokens(strings.Fields(v)[0], c, originalHost, nil))
+		}
+		if err != nil {
+			return err
+		}
+		config.HostKeyCallback = cb
+		c.hostKeyID = "known_hosts:" + strings.Join(files, ",")
+	}
+
+	if v, ok := sc.get("proxyjump"); ok {
+		if v == "none" {
+			v = ""
+		}
+		c.proxyJump = v
+	}
+
+	if v, ok := sc.get("proxycommand"); ok && v != "none" {
+		c.ProxyCommand = v
+	}
+
+	var identities []string
+	for _, file := range sc.identityFiles {
+		if file != "none" {
+			identities = append(identities, expandSSHTokens(file, c, originalHost, nil))
+		}
+	}
+
+	if len(identities) > 0 {
+		// Keys are loaded lazily during the handshake, unreadable or
+		// passphrase protected keys are skipped like OpenSSH does in batch mode.
+		config.Auth = append(config.Auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var signers []ssh.Signer
+			for _, file := range identities {
+				if signer, err := ParseKeyFile(file, ""); err == nil {
+					signers = append(signers, signer)
+				}
+			}
+			return signers, nil
+		}))
+		c.authIDs = append(c.authIDs, "identityfile:"+strings.Join(identities, ","))
+	}
+
+	return nil
+}
+
+// applyAlgorithmList applies an OpenSSH algorithm list to defaults, the list
+// may start with "+" to append, "-" to remove or "^" to prepend algorithms.
+func applyAlgorithmList(defaults []string, list string) []string {
+
+	if list == "" {
+		return defaults
+	}
+
+	op, names := list[0], strings.Split(strings.TrimLeft(list, "+-^"), ",")
+
+	switch op {
+	case '+':
+		return appendUnique(append([]string{}, defaults...), names...)
+	case '^':
+		return appendUnique(append([]string{}, names...), defaults...)
+	case '-':
+		var algos []string
+		for _, algo := range defaults {
+			if !matchPatternList(algo, names) {
+				algos = append(algos, algo)
+			}
+		}
+		return algos
+	default:
+		return names
+	}
+}
+
+// appendUnique appends the values not already present in list.
+func appendUnique(list []string, values ...string) []string {
+
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
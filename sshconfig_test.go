@@ -0,0 +1,158 @@
+package goph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func writeSSHConfig(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSSHConfigResolve(t *testing.T) {
+	dir := t.TempDir()
+
+	writeSSHConfig(t, dir, "extra.conf", `
+Host prod
+	Port 2200
+`)
+
+	path := writeSSHConfig(t, dir, "config", `
+Include extra.conf
+
+# Aliases
+Host prod !prod-db
+	HostName 10.0.0.5
+	User deploy
+
+Host prod-db
+	HostName 10.0.0.6
+
+Match host 10.0.0.*
+	ConnectTimeout 7
+	ProxyJump bastion,admin@bastion2:2222
+
+Host *
+	User fallback
+	Port 22
+`)
+
+	c := &Client{Addr: "prod", Port: 22}
+	config := &ssh.ClientConfig{}
+
+	if err := applySSHConfig(c, config, path, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Addr != "10.0.0.5" {
+		t.Errorf("expected addr 10.0.0.5, got %q", c.Addr)
+	}
+	if c.User != "deploy" || config.User != "deploy" {
+		t.Errorf("expected user deploy, got %q", c.User)
+	}
+	if c.Port != 2200 {
+		t.Errorf("expected port 2200 from include, got %d", c.Port)
+	}
+	if config.Timeout != 7*time.Second {
+		t.Errorf("expected 7s timeout, got %s", config.Timeout)
+	}
+	if c.proxyJump != "bastion,admin@bastion2:2222" {
+		t.Errorf("unexpected proxy jump %q", c.proxyJump)
+	}
+
+	// Negated pattern must not match, and the explicit user wins.
+	c = &Client{User: "root", Addr: "prod-db", Port: 22}
+	config = &ssh.ClientConfig{User: "root"}
+
+	if err := applySSHConfig(c, config, path, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Addr != "10.0.0.6" || c.User != "root" || c.Port != 22 {
+		t.Errorf("unexpected resolution for prod-db: %+v", c)
+	}
+}
+
+func TestSSHConfigMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing")
+
+	if err := applySSHConfig(&Client{Addr: "host"}, &ssh.ClientConfig{}, path, false); err != nil {
+		t.Fatalf("missing optional config should be skipped, got %v", err)
+	}
+
+	if err := applySSHConfig(&Client{Addr: "host"}, &ssh.ClientConfig{}, path, true); err == nil {
+		t.Fatal("missing explicit config should return an error")
+	}
+}
+
+func TestParseJumpHost(t *testing.T) {
+	tests := []struct {
+		spec, user, host string
+		port             uint
+	}{
+		{"bastion", "", "bastion", 0},
+		{"admin@bastion:2222", "admin", "bastion", 2222},
+		{"ssh://admin@[::1]:22", "admin", "::1", 22},
+		{"[fe80::1]", "", "fe80::1", 0},
+	}
+
+	for _, tt := range tests {
+		user, host, port, err := parseJumpHost(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		if user != tt.user || host != tt.host || port != tt.port {
+			t.Errorf("%s: got %q %q %d", tt.spec, user, host, port)
+		}
+	}
+}
+
+func TestParseJumpHostInvalid(t *testing.T) {
+	for _, spec := range []string{"", "admin@", "host:ssh", "host:70000", "[::1", "admin@:22"} {
+		if _, _, _, err := parseJumpHost(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestParseJumpHosts(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  []string
+	}{
+		{[]string{"admin@bastion:2222,host2"}, []string{"admin@bastion:2222", "host2"}},
+		{[]string{" a , b ", "c"}, []string{"a", "b", "c"}},
+		{[]string{",,"}, nil},
+		{nil, nil},
+	}
+
+	for _, tt := range tests {
+		hops := ParseJumpHosts(tt.specs...)
+		if len(hops) != len(tt.want) {
+			t.Fatalf("%q: got %d hops, want %d", tt.specs, len(hops), len(tt.want))
+		}
+		for i, hop := range hops {
+			if hop.Spec != tt.want[i] || hop.Options != nil {
+				t.Errorf("%q: hop %d is %+v, want %q", tt.specs, i, hop, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestExpandSSHTokensQuote(t *testing.T) {
+
+	c := &Client{User: "o'neil", Addr: "host; touch pwned", Port: 22}
+
+	got := expandSSHTokens("nc %h %p # %r %%", c, c.Addr, Quote)
+	if want := `nc 'host; touch pwned' 22 # 'o'\''neil' %`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrSudo matches, with errors.Is, any *SudoError.
+	ErrSudo = errors.New("goph: sudo failed")
+
+	// ErrSudoPassword is the SudoError reason when the password is incorrect.
+	ErrSudoPassword = errors.New("incorrect password")
+
+	// ErrSudoPasswordRequired is the SudoError reason when sudo needs a
+	// password and none was given, see WithUser.
+	ErrSudoPasswordRequired = errors.New("a password is required")
+
+	// ErrSudoNotAllowed is the SudoError reason when the user is not in the
+	// sudoers or may not run the command as the target user.
+	ErrSudoNotAllowed = errors.New("not allowed")
+)
+
+// SudoOptions configures a command run with sudo, see WithSudo.
+type SudoOptions struct {
+
+	// User is the target user, root if empty.
+	User string
+
+	// Password is called when sudo prompts for the password, the prompt is
+	// answered once. If nil, sudo runs non interactively and fails if a
+	// password is required.
+	Password func() (string, error)
+}
+
+// SudoError is returned when sudo fails before running the command.
+type SudoError struct {
+
+	// Reason is ErrSudoPassword, ErrSudoPasswordRequired, ErrSudoNotAllowed
+	// or nil if the sudo message is not known.
+	Reason error
+
+	// User is the target user.
+	User string
+
+	// Message is the sudo error output.
+	Message string
+
+	// Err is the command error, usually an *ExitError, or the Password error.
+	Err error
+}
+
+// Error returns a description of the failure.
+func (e *SudoError) Error() string {
+
+	if e.Reason != nil {
+		return fmt.Sprintf("goph: sudo as %s: %s", e.User, e.Reason)
+	}
+
+	if e.Message != "" {
+		return fmt.Sprintf("goph: sudo as %s: %s", e.User, e.Message)
+	}
+
+	return fmt.Sprintf("goph: sudo as %s: %s", e.User, e.Err)
+}
+
+// Unwrap returns the command error.
+func (e *SudoError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrSudo or the error reason.
+func (e *SudoError) Is(target error) bool {
+	return target == ErrSudo || (e.Reason != nil && target == e.Reason)
+}
+
+// Sudo runs cmd with sudo as root and returns its combined output, like
+// RunContext, see WithSudo.
+//
+// password is called when sudo prompts. Once a password worked it is cached
+// in the client and used for the next calls, if it stops working, password
+// is called again. A sudo failure, like a wrong password or a user not in the
+// sudoers, is returned as *SudoError.
+func (c *Client) Sudo(ctx context.Context, cmd string, password func() (string, error)) ([]byte, error) {
+
+	c.mu.Lock()
+	cached := c.sudoPassword
+	c.mu.Unlock()
+
+	if cached != "" {
+
+		out, err := c.sudo(ctx, cmd, func() (string, error) {
+			return cached, nil
+		})
+
+		if !errors.Is(err, ErrSudoPassword) {
+			return out, err
+		}
+
+		c.mu.Lock()
+		if c.sudoPassword == cached {
+			c.sudoPassword = ""
+		}
+		c.mu.Unlock()
+	}
+
+	return c.sudo(ctx, cmd, password)
+}
+
+// sudo runs cmd with sudo and caches the password if it worked.
+func (c *Client) sudo(ctx context.Context, cmd string, password func() (string, error)) ([]byte, error) {
+
+	command, err := c.CommandContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer command.Close()
+
+	command.Apply(WithSudo(SudoOptions{Password: password}))
+
+	out, err := command.CombinedOutput()
+
+	if s := command.sudo; s.started && s.password != "" {
+		c.mu.Lock()
+		c.sudoPassword = s.password
+		c.mu.Unlock()
+	}
+
+	return out, err
+}
+
+// sudoState holds the markers, the stdin and the output of a sudo command.
+type sudoState struct {
+	SudoOptions
+
+	// prompt is the sudo password prompt, ready is written to stderr once
+	// the command runs as the target user.
+	prompt, ready string
+
+	// stdin is the session stdin, the command Stdin is copied to it once ready.
+	stdin io.WriteCloser
+
+	// writer holds the sudo output until the command runs.
+	writer *sudoWriter
+
+	// answered reports whether the prompt was answered, password is the answer.
+	answered bool
+	password string
+
+	// started reports whether the command runs as the target user.
+	started bool
+
+	// err is the Password error.
+	err error
+
+	// plain runs sudo without the ready marker, the output is not held.
+	plain bool
+}
+
+// wrap returns cmd run by sudo as the target user.
+func (s *sudoState) wrap(cmd string) string {
+
+	if s.plain {
+		return fmt.Sprintf("sudo -n -u %s -- sh -c %s", Quote(s.user()), Quote(cmd))
+	}
+
+	cmd = Quote("echo " + s.ready + " >&2; " + cmd)
+
+	if s.Password == nil {
+		return fmt.Sprintf("sudo -n -u %s -- sh -c %s", Quote(s.user()), cmd)
+	}
+
+	return fmt.Sprintf("sudo -S -p %s -u %s -- sh -c %s", Quote(s.prompt), Quote(s.user()), cmd)
+}
+
+// user returns the target user.
+func (s *sudoState) user() string {
+
+	if s.User == "" {
+		return "root"
+	}
+
+	return s.User
+}
+
+// setupSudo holds the command stderr until the command runs as the target
+// user and, with a password, takes over stdin to answer the sudo prompt.
+// The markers and the sudo output are stripped from the command output.
+//
+// Without a password, the output is only held to report sudo failures as
+// *SudoError, so with a stderr pipe it is not, and sudo failures are
+// returned as *ExitError.
+func (c *Cmd) setupSudo() error {
+
+	if c.sudo == nil {
+		return nil
+	}
+
+	// With a pty, sudo writes to the terminal, which is the stdout stream.
+	pipe := c.stderrPipe || (c.pty != nil && c.stdoutPipe)
+
+	if c.sudo.Password == nil && pipe {
+		c.sudo.plain = true
+		return nil
+	}
+
+	if pipe || (c.sudo.Password != nil && c.stdinPipe) {
+		return errors.New("goph: sudo with a password requires the command stdin and stderr, not pipes")
+	}
+
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+
+	s := c.sudo
+	s.prompt = fmt.Sprintf("[goph-sudo-%x] ", token)
+	s.ready = fmt.Sprintf("goph-sudo-ready-%x", token)
+
+	s.writer = &sudoWriter{
+		prompt: []byte(s.prompt),
+		ready:  []byte(s.ready + "\n"),
+		onPrompt: func(w *sudoWriter) {
+			s.answerPrompt(w)
+		},
+		onReady: func() {
+			s.started = true
+		},
+	}
+
+	if c.pty != nil {
+		s.writer.dst, c.Stdout = c.Stdout, s.writer
+	} else {
+		s.writer.dst, c.Stderr = c.Stderr, s.writer
+	}
+
+	// The writer flushes what it holds before the line writers are closed.
+	closers := []io.Closer{s.writer}
+
+	if s.Password != nil {
+
+		stdin := c.Stdin
+		c.Stdin = nil
+
+		sw, err := c.Session.StdinPipe()
+		if err != nil {
+			return err
+		}
+
+		// The prompt, the stdin copy and Wait may all close it.
+		w := &pipeWriter{WriteCloser: sw}
+		s.stdin = w
+
+		s.writer.onReady = func() {
+			s.started = true
+			go func() {
+				if stdin != nil {
+					io.Copy(w, stdin)
+				}
+				w.Close()
+			}()
+		}
+
+		closers = append(closers, w)
+	}
+
+	c.closeAfterWait = append(closers, c.closeAfterWait...)
+	return nil
+}
+
+// answerPrompt writes the password to sudo the first time it prompts, and
+// closes stdin on the next prompts, so sudo fails instead of waiting.
+func (s *sudoState) answerPrompt(w *sudoWriter) {
+
+	if s.stdin == nil {
+		return
+	}
+
+	if s.answered {
+		s.stdin.Close()
+		return
+	}
+	s.answered = true
+
+	password, err := s.Password()
+	if err != nil {
+		s.err = err
+		s.stdin.Close()
+		return
+	}
+
+	s.password = password
+	w.secret = []byte(password)
+	io.WriteString(s.stdin, password+"\n")
+}
+
+// wrapError returns a *SudoError if sudo failed before running the command.
+func (s *sudoState) wrapError(err error) error {
+
+	if err == nil || s.started || s.plain {
+		return err
+	}
+
+	e := &SudoError{
+		User:    s.user(),
+		Message: strings.TrimSpace(string(s.writer.held)),
+		Err:     err,
+	}
+
+	if s.err != nil {
+		e.Err = s.err
+		return e
+	}
+
+	msg := strings.ToLower(e.Message)
+	switch {
+	case strings.Contains(msg, "incorrect password"), strings.Contains(msg, "sorry, try again"):
+		e.Reason = ErrSudoPassword
+	case strings.Contains(msg, "password is required"), strings.Contains(msg, "no password was provided"):
+		e.Reason = ErrSudoPasswordRequired
+	case strings.Contains(msg, "not in the sudoers"), strings.Contains(msg, "not allowed"), strings.Contains(msg, "may not run sudo"):
+		e.Reason = ErrSudoNotAllowed
+	}
+
+	return e
+}
+
+// sudoWriter holds the sudo output until the ready marker, calling onPrompt
+// for each prompt, then passes the command output through to dst.
+type sudoWriter struct {
+	dst      io.Writer
+	prompt   []byte
+	ready    []byte
+	onPrompt func(*sudoWriter)
+	onReady  func()
+	buf      []byte
+	done     bool
+
+	// secret is redacted from the held output, a pty may echo it.
+	secret []byte
+
+	// held is the sudo output, when the command did not run.
+	held []byte
+}
+
+func (w *sudoWriter) Write(p []byte) (int, error) {
+
+	if w.done {
+		return w.write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.Index(w.buf, w.prompt)
+		if i < 0 {
+			break
+		}
+
+		w.buf = append(w.buf[:i], w.buf[i+len(w.prompt):]...)
+		w.onPrompt(w)
+	}
+
+	// The output before the ready marker is from sudo, it is dropped.
+	if i := bytes.Index(w.buf, w.ready); i >= 0 {
+
+		w.done = true
+		rest := w.buf[i+len(w.ready):]
+		w.buf = nil
+		w.onReady()
+
+		if _, err := w.write(rest); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close flushes the held sudo output when the command did not run.
+func (w *sudoWriter) Close() error {
+
+	if !w.done && len(w.buf) > 0 {
+
+		if len(w.secret) > 0 {
+			w.buf = bytes.ReplaceAll(w.buf, w.secret, nil)
+		}
+
+		w.held = w.buf
+		w.write(w.buf)
+		w.buf = nil
+	}
+
+	return nil
+}
+
+func (w *sudoWriter) write(p []byte) (int, error) {
+
+	if w.dst == nil {
+		return len(p), nil
+	}
+
+	return w.dst.Write(p)
+}
@@ -0,0 +1,127 @@
+package goph
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+	closed bool
+}
+
+func (w *nopWriteCloser) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestCmdCommandWrap(t *testing.T) {
+
+	c := &Cmd{Path: "ls", Dir: "/var/my logs", umask: "0027", sudo: &sudoState{SudoOptions: SudoOptions{User: "www"}, ready: "R"}}
+	want := `sudo -n -u www -- sh -c 'echo R >&2; umask 0027; cd '\''/var/my logs'\'' || exit; ls'`
+	if got := c.command(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSetupSudoPipes(t *testing.T) {
+
+	c := &Cmd{Session: &ssh.Session{}, Path: "id", stderrPipe: true}
+	WithUser("deploy")(c)
+
+	if err := c.setupSudo(); err != nil {
+		t.Fatalf("sudo without password and a stderr pipe: %v", err)
+	}
+
+	if c.Stderr != nil {
+		t.Error("stderr should not be intercepted without a password")
+	}
+
+	if got, want := c.command(), "sudo -n -u deploy -- sh -c id"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	c = &Cmd{Path: "id", stderrPipe: true}
+	WithSudo(SudoOptions{Password: func() (string, error) { return "", nil }})(c)
+
+	if err := c.setupSudo(); err == nil {
+		t.Error("sudo with a password and a stderr pipe should return an error")
+	}
+}
+
+func TestSudoWriter(t *testing.T) {
+
+	var stdin bytes.Buffer
+	stdinCloser := &nopWriteCloser{Writer: &stdin}
+
+	s := &sudoState{
+		SudoOptions: SudoOptions{Password: func() (string, error) { return "secret", nil }},
+		stdin:       stdinCloser,
+	}
+
+	var out bytes.Buffer
+	w := &sudoWriter{
+		dst:      &out,
+		prompt:   []byte("[p] "),
+		ready:    []byte("R\n"),
+		onPrompt: s.answerPrompt,
+		onReady:  func() { s.started = true },
+	}
+
+	w.Write([]byte("[p"))
+	w.Write([]byte("] "))
+
+	if stdin.String() != "secret\n" || out.Len() != 0 {
+		t.Fatalf("expected password on stdin and no output, got %q and %q", stdin.String(), out.String())
+	}
+
+	w.Write([]byte("secret\nR\nhello "))
+	w.Write([]byte("world\n"))
+	w.Close()
+
+	if !s.started || out.String() != "hello world\n" {
+		t.Errorf("expected started and command output, got %v and %q", s.started, out.String())
+	}
+
+	// A second prompt, after a wrong password, closes stdin.
+	s.started = false
+	out.Reset()
+	s.writer = &sudoWriter{dst: &out, prompt: []byte("[p] "), ready: []byte("R\n"), onPrompt: s.answerPrompt, secret: []byte("secret")}
+	s.writer.Write([]byte("secret\nSorry, try again.\n[p] "))
+	s.writer.Close()
+
+	if !stdinCloser.closed || out.String() != "\nSorry, try again.\n" {
+		t.Errorf("expected closed stdin and sudo error output, got %v and %q", stdinCloser.closed, out.String())
+	}
+
+	err := s.wrapError(errors.New("exit status 1"))
+	if !errors.Is(err, ErrSudo) || !errors.Is(err, ErrSudoPassword) {
+		t.Errorf("expected ErrSudoPassword, got %v", err)
+	}
+}
+
+func TestSudoErrorReason(t *testing.T) {
+
+	tests := map[string]error{
+		"sudo: a password is required":                 ErrSudoPasswordRequired,
+		"deploy is not in the sudoers file.":           ErrSudoNotAllowed,
+		"Sorry, user deploy may not run sudo on host.": ErrSudoNotAllowed,
+		"sudo: 1 incorrect password attempt":           ErrSudoPassword,
+		"sudo: sorry, you must have a tty to run sudo": nil,
+	}
+
+	for msg, want := range tests {
+
+		s := &sudoState{writer: &sudoWriter{held: []byte(msg)}}
+		err := s.wrapError(errors.New("exit status 1"))
+
+		var sudoErr *SudoError
+		if !errors.As(err, &sudoErr) || sudoErr.Reason != want || sudoErr.User != "root" {
+			t.Errorf("%q: got %v, want reason %v", msg, err, want)
+		}
+	}
+}
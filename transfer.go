@@ -0,0 +1,1033 @@
+// Copyright 2026 Mohammed El Bahja. All rights reserved.
+// Use of this source code is governed by a MIT license.
+
+package goph
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// DefaultProgressInterval is the default interval between progress reports.
+const DefaultProgressInterval = 500 * time.Millisecond
+
+// SymlinkPolicy is how UploadDir and DownloadDir handle symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinkPreserve recreates the links with the same target (default).
+	SymlinkPreserve SymlinkPolicy = iota
+
+	// SymlinkFollow transfers the files and directories the links point to.
+	SymlinkFollow
+
+	// SymlinkSkip ignores the links.
+	SymlinkSkip
+)
+
+// TransferOption configures a file or directory transfer.
+type TransferOption func(*transferOptions)
+
+// transferOptions holds the transfer settings.
+type transferOptions struct {
+	symlinks SymlinkPolicy
+	include  []string
+	exclude  []string
+	failFast bool
+
+	progress         func(Progress)
+	progressInterval time.Duration
+
+	resume       bool
+	resumeVerify bool
+
+	atomic bool
+	mode   *os.FileMode
+	owner  *[2]int
+}
+
+// Progress is a transfer progress report, see WithProgress.
+type Progress struct {
+
+	// Path is the source path of the file being transferred.
+	Path string
+
+	// Bytes is the number of bytes transferred, including the offsets of
+	// resumed files.
+	Bytes int64
+
+	// Total is the number of bytes to transfer, -1 if unknown.
+	Total int64
+
+	// Rate is the average rate in bytes per second, the resumed offsets are
+	// not counted as sent.
+	Rate float64
+
+	// ETA is the estimated remaining time, zero if unknown.
+	ETA time.Duration
+
+	// Elapsed is the time since the transfer started.
+	Elapsed time.Duration
+
+	// Done reports the last report of the transfer, on success or failure.
+	Done bool
+}
+
+// WithProgress calls fn with the transfer progress at most once per interval
+// (default DefaultProgressInterval) and once when the transfer is done.
+// Directory transfers walk the source tree first to compute the total.
+func WithProgress(fn func(Progress), interval time.Duration) TransferOption {
+	return func(o *transferOptions) {
+		o.progress = fn
+		o.progressInterval = interval
+	}
+}
+
+// WithSymlinks sets how directory transfers handle symbolic links.
+func WithSymlinks(policy SymlinkPolicy) TransferOption {
+	return func(o *transferOptions) {
+		o.symlinks = policy
+	}
+}
+
+// WithInclude only transfers the files matching one of the glob patterns,
+// see WithExclude for the pattern syntax. Directories are always walked.
+func WithInclude(patterns ...string) TransferOption {
+	return func(o *transferOptions) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// WithExclude skips the files and directories matching one of the glob
+// patterns. Patterns use path.Match syntax, a pattern with a "/" matches the
+// slash separated path relative to the transfer root, otherwise the base name.
+func WithExclude(patterns ...string) TransferOption {
+	return func(o *transferOptions) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// WithFailFast aborts a directory transfer at the first file error,
+// instead of transferring the other files and returning TransferErrors.
+func WithFailFast() TransferOption {
+	return func(o *transferOptions) {
+		o.failFast = true
+	}
+}
+
+// WithResume resumes the transfer of partial destination files: when the
+// destination is smaller than the source, only the rest is copied. With
+// verify, the existing prefix is hashed on both sides first, which reads it
+// again, and the file is copied from the start if they differ. A destination
+// larger than the source is copied again.
+func WithResume(verify bool) TransferOption {
+	return func(o *transferOptions) {
+		o.resume = true
+		o.resumeVerify = verify
+	}
+}
+
+// WithAtomic uploads to a temp file in the remote directory, syncs it with the
+// fsync@openssh.com extension when the server supports it, and renames it over
+// the target, so readers never see a partial file. The mode and owner of the
+// replaced file are kept unless WithMode or WithOwner are set, and the temp
+// file is removed on failure. WithResume is ignored.
+//
+// The rename is atomic with the posix-rename@openssh.com extension, without
+// it the target is removed first.
+func WithAtomic() TransferOption {
+	return func(o *transferOptions) {
+		o.atomic = true
+	}
+}
+
+// WithMode sets the permissions of the uploaded files.
+func WithMode(mode os.FileMode) TransferOption {
+	return func(o *transferOptions) {
+		o.mode = &mode
+	}
+}
+
+// WithOwner sets the owner and group ids of the uploaded files, the remote
+// user must be allowed to change them.
+func WithOwner(uid, gid int) TransferOption {
+	return func(o *transferOptions) {
+		o.owner = &[2]int{uid, gid}
+	}
+}
+
+// TransferError is the error of a file of a directory transfer.
+type TransferError struct {
+
+	// Path is the slash separated path relative to the transfer root.
+	Path string
+
+	// Err is the file error.
+	Err error
+}
+
+// Error returns the path and its error.
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// Unwrap returns the file error.
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// TransferErrors is returned by UploadDir and DownloadDir when some files
+// failed, the other files are transferred.
+type TransferErrors []*TransferError
+
+// Error returns a summary of the failures.
+func (e TransferErrors) Error() string {
+
+	if len(e) == 1 {
+		return "goph: transfer: " + e[0].Error()
+	}
+
+	return fmt.Sprintf("goph: transfer: %d files failed, first: %s", len(e), e[0])
+}
+
+// Unwrap returns the file errors.
+func (e TransferErrors) Unwrap() []error {
+
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// UploadDir copies the local directory tree to remoteDir with one SFTP
+// session, creating the directories and keeping the file permissions.
+// Files that fail are reported in TransferErrors unless WithFailFast is set.
+func (c *Client) UploadDir(localDir, remoteDir string, opts ...TransferOption) error {
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	t := newTransfer(ftp, opts)
+
+	return t.walkDir(localFS{}, localDir, transferOps{
+		mkdir: func(rel string, fi os.FileInfo) error {
+			return ftp.MkdirAll(path.Join(remoteDir, rel))
+		},
+		file: func(rel, src string, fi os.FileInfo) error {
+			dst := path.Join(remoteDir, rel)
+			if err := t.upload(src, dst); err != nil || t.opts.mode != nil {
+				return err
+			}
+			return ftp.Chmod(dst, fi.Mode().Perm())
+		},
+		link: func(rel, target string) error {
+			dst := path.Join(remoteDir, rel)
+			ftp.Remove(dst)
+			return ftp.Symlink(target, dst)
+		},
+	})
+}
+
+// DownloadDir copies the remote directory tree to localDir with one SFTP
+// session, creating the directories and keeping the file permissions.
+// Files that fail are reported in TransferErrors unless WithFailFast is set.
+// Remote entries with unsafe names are rejected, and nothing is written
+// outside localDir or through a symlink created by the transfer.
+func (c *Client) DownloadDir(remoteDir, localDir string, opts ...TransferOption) error {
+
+	ftp, err := c.NewSftp()
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+
+	t := newTransfer(ftp, opts)
+
+	return t.walkDir(remoteFS{ftp}, remoteDir, t.downloadOps(localDir, t.download))
+}
+
+// downloadOps returns the ops of DownloadDir writing under localDir, get
+// downloads a file. The destinations are checked to stay in localDir and
+// not to go through a symlink created by the transfer, as the remote tree
+// is not trusted.
+func (t *transfer) downloadOps(localDir string, get func(src, dst string) error) transferOps {
+
+	root := filepath.Clean(localDir)
+	links := map[string]bool{}
+
+	// local returns the local path of rel, with through set to allow rel
+	// itself to be a created link.
+	local := func(rel string, through bool) (string, error) {
+
+		dst := filepath.Join(root, filepath.FromSlash(rel))
+		if r, err := filepath.Rel(root, dst); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is outside of %s", dst, root)
+		}
+
+		for p := rel; p != "." && p != ""; p = path.Dir(p) {
+			if links[p] && (p != rel || !through) {
+				return "", fmt.Errorf("%s goes through the symlink %s", rel, p)
+			}
+		}
+
+		return dst, nil
+	}
+
+	return transferOps{
+		mkdir: func(rel string, fi os.FileInfo) error {
+
+			dst, err := local(rel, false)
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(dst, 0755)
+		},
+		file: func(rel, src string, fi os.FileInfo) error {
+
+			dst, err := local(rel, false)
+			if err != nil {
+				return err
+			}
+
+			if err = get(src, dst); err != nil {
+				return err
+			}
+			return os.Chmod(dst, fi.Mode().Perm())
+		},
+		link: func(rel, target string) error {
+
+			dst, err := local(rel, true)
+			if err != nil {
+				return err
+			}
+
+			os.Remove(dst)
+			if err = os.Symlink(target, dst); err != nil {
+				return err
+			}
+
+			links[rel] = true
+			return nil
+		},
+	}
+}
+
+// transfer copies files with an SFTP session.
+type transfer struct {
+	ftp      *sftp.Client
+	opts     transferOptions
+	errs     TransferErrors
+	progress *progress
+}
+
+func newTransfer(ftp *sftp.Client, opts []TransferOption) *transfer {
+
+	t := &transfer{ftp: ftp}
+	for _, opt := range opts {
+		opt(&t.opts)
+	}
+
+	if t.opts.progress != nil {
+
+		interval := t.opts.progressInterval
+		if interval <= 0 {
+			interval = DefaultProgressInterval
+		}
+
+		t.progress = &progress{
+			fn:       t.opts.progress,
+			interval: interval,
+			start:    time.Now(),
+			total:    -1,
+		}
+	}
+
+	return t
+}
+
+// finish sends the last progress report.
+func (t *transfer) finish() {
+
+	if t.progress != nil {
+		t.progress.report(true)
+	}
+}
+
+// reader counts the bytes read from r as transferred bytes of the file,
+// the offset bytes of a resumed transfer are counted as transferred.
+func (t *transfer) reader(r io.Reader, file string, size, offset int64) io.Reader {
+
+	if t.progress == nil {
+		return r
+	}
+
+	t.progress.begin(file, size, offset)
+	return &progressReader{r: r, p: t.progress}
+}
+
+// writer counts the bytes written to w as transferred bytes of the file,
+// downloads count on the local side to keep the SFTP concurrent reads.
+func (t *transfer) writer(w io.Writer, file string, size, offset int64) io.Writer {
+
+	if t.progress == nil {
+		return w
+	}
+
+	t.progress.begin(file, size, offset)
+	return &progressWriter{w: w, p: t.progress}
+}
+
+// upload copies the local file to remotePath.
+func (t *transfer) upload(localPath, remotePath string) error {
+
+	local, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	fi, err := local.Stat()
+	if err != nil {
+		return err
+	}
+
+	if t.opts.atomic {
+		return t.uploadAtomic(local, fi.Size(), remotePath)
+	}
+
+	var offset int64
+	if t.opts.resume {
+
+		var size int64
+		if rfi, err := t.ftp.Stat(remotePath); err == nil {
+			size = rfi.Size()
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		offset, err = t.resumeOffset(local, fi.Size(), size, func() (io.ReadCloser, error) {
+			return t.ftp.Open(remotePath)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	remote, err := t.ftp.OpenFile(remotePath, openFlags(offset))
+	if err != nil {
+		return err
+	}
+
+	r := t.reader(local, localPath, fi.Size(), offset)
+	if err = seek(offset, local, remote); err == nil {
+		_, err = io.Copy(remote, r)
+	}
+
+	if cerr := remote.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return t.setAttrs(remotePath, nil)
+}
+
+// uploadAtomic writes local to a temp file next to remotePath and renames it
+// over remotePath, see WithAtomic.
+func (t *transfer) uploadAtomic(local *os.File, size int64, remotePath string) (err error) {
+
+	prev, err := t.ftp.Stat(remotePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	token := make([]byte, 8)
+	if _, err = rand.Read(token); err != nil {
+		return err
+	}
+
+	tmp := path.Join(path.Dir(remotePath), fmt.Sprintf(".%s.goph-%x.tmp", path.Base(remotePath), token))
+
+	f, err := t.ftp.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			t.ftp.Remove(tmp)
+		}
+	}()
+
+	// The attributes are set before the content is written.
+	if err = t.setAttrs(tmp, prev); err == nil {
+		if _, err = io.Copy(f, t.reader(local, local.Name(), size, 0)); err == nil {
+			err = syncFile(f)
+		}
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return t.rename(tmp, remotePath)
+}
+
+// setAttrs sets the WithMode and WithOwner attributes of the remote file, or
+// those of prev, the replaced file of an atomic upload. Keeping the owner of
+// prev is best effort, the remote user may not be allowed to.
+func (t *transfer) setAttrs(name string, prev os.FileInfo) error {
+
+	switch {
+	case t.opts.mode != nil:
+		if err := t.ftp.Chmod(name, *t.opts.mode); err != nil {
+			return err
+		}
+	case prev != nil:
+		if err := t.ftp.Chmod(name, prev.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case t.opts.owner != nil:
+		return t.ftp.Chown(name, t.opts.owner[0], t.opts.owner[1])
+	case prev != nil:
+		if st, ok := prev.Sys().(*sftp.FileStat); ok {
+			t.ftp.Chown(name, int(st.UID), int(st.GID))
+		}
+	}
+
+	return nil
+}
+
+// rename renames the temp file over the target, see WithAtomic.
+func (t *transfer) rename(from, to string) error {
+
+	if _, ok := t.ftp.HasExtension("posix-rename@openssh.com"); ok {
+		return t.ftp.PosixRename(from, to)
+	}
+
+	if err := t.ftp.Rename(from, to); err == nil {
+		return nil
+	}
+
+	// A plain SFTP rename fails if the target exists.
+	if err := t.ftp.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return t.ftp.Rename(from, to)
+}
+
+// syncFile flushes the remote file to disk, servers without the
+// fsync@openssh.com extension are ignored.
+func syncFile(f *sftp.File) error {
+
+	err := f.Sync()
+
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return nil
+	}
+
+	return err
+}
+
+// download copies the remote file to localPath.
+func (t *transfer) download(remotePath, localPath string) error {
+
+	remote, err := t.ftp.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	fi, err := remote.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if t.opts.resume {
+
+		var size int64
+		if lfi, err := os.Stat(localPath); err == nil {
+			size = lfi.Size()
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		offset, err = t.resumeOffset(remote, fi.Size(), size, func() (io.ReadCloser, error) {
+			return os.Open(localPath)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	local, err := os.OpenFile(localPath, openFlags(offset), 0666)
+	if err != nil {
+		return err
+	}
+
+	w := t.writer(local, remotePath, fi.Size(), offset)
+	if err = seek(offset, local, remote); err == nil {
+		if _, err = io.Copy(w, remote); err == nil {
+			err = local.Sync()
+		}
+	}
+
+	if cerr := local.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// resumeOffset returns the offset to resume a transfer from, the size of the
+// partial destination file if it is a prefix of the source, zero otherwise.
+// With verify, the prefix is hashed on both sides, which reads it again.
+func (t *transfer) resumeOffset(src io.ReadSeeker, srcSize, dstSize int64, openDst func() (io.ReadCloser, error)) (int64, error) {
+
+	if dstSize <= 0 || dstSize > srcSize {
+		return 0, nil
+	}
+
+	if !t.opts.resumeVerify {
+		return dstSize, nil
+	}
+
+	dst, err := openDst()
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	srcSum, err := hashPrefix(src, dstSize)
+	if err != nil {
+		return 0, err
+	}
+
+	dstSum, err := hashPrefix(dst, dstSize)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if !bytes.Equal(srcSum, dstSum) {
+		return 0, nil
+	}
+
+	return dstSize, nil
+}
+
+// hashPrefix returns the SHA-256 of the first n bytes of r.
+func hashPrefix(r io.Reader, n int64) ([]byte, error) {
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, n); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// openFlags returns the destination open flags, the file is kept to resume
+// from a non zero offset and truncated otherwise.
+func openFlags(offset int64) int {
+
+	if offset > 0 {
+		return os.O_WRONLY | os.O_CREATE
+	}
+
+	return os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+}
+
+// seek moves the files to offset to resume a transfer.
+func seek(offset int64, files ...io.Seeker) error {
+
+	if offset == 0 {
+		return nil
+	}
+
+	for _, f := range files {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// transferOps are the destination operations of a directory transfer,
+// rel is the slash separated path relative to the transfer root.
+type transferOps struct {
+	mkdir func(rel string, fi os.FileInfo) error
+	file  func(rel, src string, fi os.FileInfo) error
+	link  func(rel, target string) error
+}
+
+// treeFS is the source file system of a directory transfer.
+type treeFS interface {
+	ReadDir(name string) ([]os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadLink(name string) (string, error)
+	RealPath(name string) (string, error)
+	Join(elem ...string) string
+}
+
+// walkDir walks the source tree root and applies ops for each entry.
+func (t *transfer) walkDir(fsys treeFS, root string, ops transferOps) error {
+
+	fi, err := fsys.Stat(root)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("goph: transfer: %s is not a directory", root)
+	}
+
+	if err = ops.mkdir("", fi); err != nil {
+		return err
+	}
+
+	if t.progress != nil {
+		t.progress.total = t.size(fsys, root)
+		defer t.finish()
+	}
+
+	if err = t.walk(fsys, root, "", ops, map[string]bool{}); err != nil {
+		return err
+	}
+
+	if len(t.errs) > 0 {
+		return t.errs
+	}
+
+	return nil
+}
+
+// walk transfers the entries of dir, visited holds the real paths of the
+// followed directories to stop on symlink loops. It only returns an error
+// to abort the transfer.
+func (t *transfer) walk(fsys treeFS, dir, rel string, ops transferOps, visited map[string]bool) error {
+
+	if t.opts.symlinks == SymlinkFollow {
+
+		real, err := fsys.RealPath(dir)
+		if err != nil {
+			return t.fail(rel, err)
+		}
+
+		if visited[real] {
+			return t.fail(rel, errors.New("symlink loop"))
+		}
+
+		visited[real] = true
+		defer delete(visited, real)
+	}
+
+	infos, err := fsys.ReadDir(dir)
+	if err != nil {
+		return t.fail(rel, err)
+	}
+
+	for _, fi := range infos {
+
+		if !validName(fi.Name()) {
+			if err = t.fail(rel, fmt.Errorf("invalid entry name %q", fi.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		src := fsys.Join(dir, fi.Name())
+		name := path.Join(rel, fi.Name())
+
+		if matchGlobs(t.opts.exclude, name) {
+			continue
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+
+			switch t.opts.symlinks {
+			case SymlinkSkip:
+				continue
+
+			case SymlinkPreserve:
+
+				if len(t.opts.include) > 0 && !matchGlobs(t.opts.include, name) {
+					continue
+				}
+
+				target, err := fsys.ReadLink(src)
+				if err == nil {
+					err = ops.link(name, target)
+				}
+
+				if err != nil {
+					if err = t.fail(name, err); err != nil {
+						return err
+					}
+				}
+				continue
+
+			case SymlinkFollow:
+				if fi, err = fsys.Stat(src); err != nil {
+					if err = t.fail(name, err); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+		}
+
+		switch {
+		case fi.IsDir():
+
+			if err = ops.mkdir(name, fi); err != nil {
+				if err = t.fail(name, err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err = t.walk(fsys, src, name, ops, visited); err != nil {
+				return err
+			}
+
+		case fi.Mode().IsRegular():
+
+			if len(t.opts.include) > 0 && !matchGlobs(t.opts.include, name) {
+				continue
+			}
+
+			if err = ops.file(name, src, fi); err != nil {
+				if err = t.fail(name, err); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// size returns the number of bytes of the files a walk of root transfers.
+func (t *transfer) size(fsys treeFS, root string) (total int64) {
+
+	scan := &transfer{opts: t.opts}
+	scan.opts.failFast = false
+
+	scan.walk(fsys, root, "", transferOps{
+		mkdir: func(rel string, fi os.FileInfo) error {
+			return nil
+		},
+		file: func(rel, src string, fi os.FileInfo) error {
+			total += fi.Size()
+			return nil
+		},
+		link: func(rel, target string) error {
+			return nil
+		},
+	}, map[string]bool{})
+
+	return total
+}
+
+// fail records the error of rel, it returns it with WithFailFast to abort.
+func (t *transfer) fail(rel string, err error) error {
+
+	terr := &TransferError{Path: rel, Err: err}
+	if t.opts.failFast {
+		return terr
+	}
+
+	t.errs = append(t.errs, terr)
+	return nil
+}
+
+// validName reports whether name is a single path element, names read from
+// a directory listing are not trusted to be.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// matchGlobs reports whether the slash separated rel matches one of patterns,
+// patterns without a "/" match the base name.
+func matchGlobs(patterns []string, rel string) bool {
+
+	for _, pattern := range patterns {
+
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localFS is the local treeFS.
+type localFS struct{}
+
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fi)
+	}
+
+	return infos, nil
+}
+
+func (localFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFS) ReadLink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (localFS) RealPath(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+func (localFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+// remoteFS is the SFTP treeFS.
+type remoteFS struct {
+	*sftp.Client
+}
+
+// progress tracks the transferred bytes and reports them.
+type progress struct {
+	fn       func(Progress)
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+	path     string
+	bytes    int64
+	total    int64
+
+	// resumed is the part of bytes skipped by resumed transfers.
+	resumed int64
+}
+
+// begin sets the file being transferred and counts its resumed offset,
+// single file transfers only know the total now.
+func (p *progress) begin(file string, size, offset int64) {
+
+	if p.total < 0 {
+		p.total = size
+	}
+
+	p.path = file
+	p.bytes += offset
+	p.resumed += offset
+}
+
+// add counts n transferred bytes and reports them if the interval elapsed.
+func (p *progress) add(n int) {
+
+	p.bytes += int64(n)
+
+	if time.Since(p.last) >= p.interval {
+		p.report(false)
+	}
+}
+
+// report calls fn with the current progress.
+func (p *progress) report(done bool) {
+
+	p.last = time.Now()
+
+	pr := Progress{
+		Path:    p.path,
+		Bytes:   p.bytes,
+		Total:   p.total,
+		Elapsed: p.last.Sub(p.start),
+		Done:    done,
+	}
+
+	if secs := pr.Elapsed.Seconds(); secs > 0 {
+		pr.Rate = float64(p.bytes-p.resumed) / secs
+	}
+
+	if pr.Rate > 0 && p.total > p.bytes {
+		pr.ETA = time.Duration(float64(p.total-p.bytes) / pr.Rate * float64(time.Second))
+	}
+
+	p.fn(pr)
+}
+
+// progressReader counts the bytes read from r.
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+
+	n, err := r.r.Read(b)
+	r.p.add(n)
+	return n, err
+}
+
+// progressWriter counts the bytes written to w.
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+
+	n, err := w.w.Write(b)
+	w.p.add(n)
+	return n, err
+}
@@ -0,0 +1,161 @@
+package goph
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// treeEntry is a file of hostileFS, a directory when dir is set and a
+// symlink when target is set.
+type treeEntry struct {
+	name   string
+	dir    bool
+	target string
+}
+
+func (e treeEntry) Name() string       { return e.name }
+func (e treeEntry) Size() int64        { return 0 }
+func (e treeEntry) ModTime() time.Time { return time.Time{} }
+func (e treeEntry) IsDir() bool        { return e.dir }
+func (e treeEntry) Sys() any           { return nil }
+
+func (e treeEntry) Mode() os.FileMode {
+
+	switch {
+	case e.target != "":
+		return os.ModeSymlink | 0777
+	case e.dir:
+		return os.ModeDir | 0755
+	}
+
+	return 0644
+}
+
+// hostileFS is a remote tree listing names that escape the transfer root.
+type hostileFS map[string][]treeEntry
+
+func (h hostileFS) ReadDir(name string) ([]os.FileInfo, error) {
+
+	var infos []os.FileInfo
+	for _, e := range h[name] {
+		infos = append(infos, e)
+	}
+
+	return infos, nil
+}
+
+func (h hostileFS) Stat(name string) (os.FileInfo, error) {
+	return treeEntry{name: path.Base(name), dir: true}, nil
+}
+
+func (h hostileFS) ReadLink(name string) (string, error) {
+
+	for _, e := range h[path.Dir(name)] {
+		if e.name == path.Base(name) && e.target != "" {
+			return e.target, nil
+		}
+	}
+
+	return "", os.ErrNotExist
+}
+
+func (h hostileFS) RealPath(name string) (string, error) {
+	return name, nil
+}
+
+func (h hostileFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func TestDownloadOpsHostileTree(t *testing.T) {
+
+	base := t.TempDir()
+	localDir := filepath.Join(base, "local")
+	outside := filepath.Join(base, "outside")
+	os.Mkdir(outside, 0755)
+
+	fsys := hostileFS{
+		"/r": {
+			{name: ".."},
+			{name: "."},
+			{name: ""},
+			{name: "a/../../x"},
+			{name: `..\x`},
+			{name: "ok"},
+			{name: "abs", target: outside},
+			{name: "abs", dir: true},
+			{name: "up", target: "../outside"},
+			{name: "up"},
+			{name: "sub", dir: true},
+		},
+		"/r/abs": {{name: "pwned"}},
+		"/r/sub": {{name: "..", dir: true}, {name: "file"}},
+	}
+
+	tr := &transfer{}
+	ops := tr.downloadOps(localDir, func(src, dst string) error {
+		return os.WriteFile(dst, []byte(src), 0644)
+	})
+
+	err := tr.walkDir(fsys, "/r", ops)
+
+	var errs TransferErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected TransferErrors, got %v", err)
+	}
+
+	if len(errs) != 8 {
+		t.Errorf("expected 8 failed entries, got %d: %v", len(errs), errs)
+	}
+
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("expected nothing written outside the local dir, got %v", entries)
+	}
+
+	if entries, _ := os.ReadDir(base); len(entries) != 2 {
+		t.Errorf("expected only the local and outside dirs, got %v", entries)
+	}
+
+	for _, name := range []string{"ok", "sub/file"} {
+		if _, err := os.Stat(filepath.Join(localDir, name)); err != nil {
+			t.Errorf("expected %s to be downloaded: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"abs", "up"} {
+		if fi, err := os.Lstat(filepath.Join(localDir, name)); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("expected %s to be a preserved symlink", name)
+		}
+	}
+}
+
+func TestProgressResumeRate(t *testing.T) {
+
+	var last Progress
+	p := &progress{
+		fn:    func(pr Progress) { last = pr },
+		start: time.Now().Add(-time.Second),
+		total: -1,
+	}
+
+	// 90 of the 100 bytes were transferred by a previous run.
+	p.begin("file", 100, 90)
+	p.add(5)
+	p.report(false)
+
+	if last.Bytes != 95 || last.Total != 100 {
+		t.Errorf("expected 95 of 100 bytes, got %d of %d", last.Bytes, last.Total)
+	}
+
+	if last.Rate <= 0 || last.Rate > 5 {
+		t.Errorf("expected a rate of the 5 sent bytes per second at most, got %f", last.Rate)
+	}
+
+	if last.ETA < time.Second {
+		t.Errorf("expected an ETA of at least a second, got %s", last.ETA)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -169,3 +170,43 @@ func DefaultKnownHostsPath() (string, error) {
 
 	return fmt.Sprintf("%s/.ssh/known_hosts", home), err
 }
+
+// Quote returns s quoted for a POSIX shell, so the remote shell passes it as a
+// single argument. Strings made only of safe characters are returned as is.
+func Quote(s string) string {
+
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, r := range s {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+
+	if safe {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// QuoteArgs quotes each arg with Quote and joins them with spaces.
+func QuoteArgs(args ...string) string {
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// isShellSafe reports whether r never needs quoting in a POSIX shell word.
+func isShellSafe(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune("@%_-+=:,./", r)
+}